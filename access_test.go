@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+
+	"ytdlp-site/database/dbtest"
+	"ytdlp-site/ffmpeg"
+	"ytdlp-site/handlers"
+	"ytdlp-site/media"
+	"ytdlp-site/originals"
+	"ytdlp-site/users"
+)
+
+// a user with an API token and a downloaded original
+type testUser struct {
+	user     users.User
+	token    string
+	original originals.Original
+	video    media.Video
+}
+
+// set up an in-memory database, a temporary data directory and the site's routes
+func setupTestServer(t *testing.T) *echo.Echo {
+	t.Helper()
+	t.Setenv("YTDLP_SITE_DATA_DIR", t.TempDir())
+	t.Setenv("YTDLP_SITE_SESSION_AUTH_KEY", "test")
+
+	initLogger()
+	log.SetLevel(logrus.WarnLevel)
+	originals.Init(log)
+	ffmpeg.Init(log)
+	if err := handlers.Init(log); err != nil {
+		t.Fatal(err)
+	}
+
+	db = dbtest.Open(t, models...)
+
+	e := echo.New()
+	addRoutes(e)
+	return e
+}
+
+func createTestUser(t *testing.T, name string) testUser {
+	t.Helper()
+	user, err := users.CreateUser(db, name, "password", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, token, err := users.CreateToken(db, user.ID, "test", []users.Scope{users.ScopeAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orig := originals.Original{UserID: user.ID, Title: name + "'s video", Status: originals.StatusCompleted, Video: true}
+	if err := db.Create(&orig).Error; err != nil {
+		t.Fatal(err)
+	}
+	video := media.Video{OriginalID: orig.ID, Source: "original"}
+	video.Filename = name + ".mp4"
+	if err := db.Create(&video).Error; err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(os.Getenv("YTDLP_SITE_DATA_DIR"), video.Filename)
+	if err := os.WriteFile(path, []byte(name), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return testUser{user: user, token: token, original: orig, video: video}
+}
+
+func serve(e *echo.Echo, as testUser, method, target string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+as.token)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCrossUserAccessDenied(t *testing.T) {
+	e := setupTestServer(t)
+	alice := createTestUser(t, "alice")
+	bob := createTestUser(t, "bob")
+
+	requests := []struct {
+		method string
+		target func(owner testUser) string
+		ok     int // status for the owner
+	}{
+		{http.MethodGet, func(u testUser) string { return fmt.Sprintf("/video/%d", u.original.ID) }, http.StatusOK},
+		{http.MethodGet, func(u testUser) string { return "/data/" + u.video.Filename }, http.StatusOK},
+		{http.MethodGet, func(u testUser) string { return fmt.Sprintf("/api/v1/originals/%d", u.original.ID) }, http.StatusOK},
+		{http.MethodPost, func(u testUser) string { return fmt.Sprintf("/video/%d/toggle_watched", u.original.ID) }, http.StatusSeeOther},
+		{http.MethodPost, func(u testUser) string { return fmt.Sprintf("/video/%d/delete", u.original.ID) }, http.StatusSeeOther},
+		{http.MethodDelete, func(u testUser) string { return fmt.Sprintf("/api/v1/originals/%d", u.original.ID) }, http.StatusNoContent},
+	}
+
+	// bob can't reach any of alice's originals or files
+	for _, r := range requests {
+		target := r.target(alice)
+		if rec := serve(e, bob, r.method, target); rec.Code != http.StatusNotFound {
+			t.Errorf("%s %s by another user: status %d, want %d", r.method, target, rec.Code, http.StatusNotFound)
+		}
+	}
+	var orig originals.Original
+	if err := db.First(&orig, alice.original.ID).Error; err != nil {
+		t.Fatalf("alice's original was deleted by another user: %v", err)
+	}
+	if orig.Watched {
+		t.Error("alice's original was marked watched by another user")
+	}
+
+	// and the owners can, so the denials above aren't for some other reason
+	for _, r := range requests {
+		owner := alice
+		if r.method == http.MethodDelete {
+			owner = bob // alice's original is gone by then
+		}
+		target := r.target(owner)
+		if rec := serve(e, owner, r.method, target); rec.Code != r.ok {
+			t.Errorf("%s %s by its owner: status %d, want %d", r.method, target, rec.Code, r.ok)
+		}
+	}
+	for _, owner := range []testUser{alice, bob} {
+		var deleted originals.Original
+		if err := db.First(&deleted, owner.original.ID).Error; err == nil {
+			t.Errorf("%s's original wasn't deleted by its owner", owner.user.Username)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"ytdlp-site/handlers"
+	"ytdlp-site/originals"
+	"ytdlp-site/playlists"
+	"ytdlp-site/quota"
+	"ytdlp-site/users"
+)
+
+const inviteTTL = 7 * 24 * time.Hour
+
+// delete a user along with everything they own
+func deleteUser(id uint) error {
+	var origs []originals.Original
+	if err := db.Where("user_id = ?", id).Find(&origs).Error; err != nil {
+		return err
+	}
+	for _, orig := range origs {
+		if err := deleteOriginal(orig.ID); err != nil {
+			log.Errorln("couldn't delete original", orig.ID, "of user", id, err)
+		}
+	}
+	db.Where("user_id = ?", id).Delete(&playlists.Playlist{})
+	db.Where("user_id = ?", id).Delete(&users.Token{})
+	users.DeleteSessions(db, id)
+	db.Unscoped().Where("user_id = ?", id).Delete(&users.RecoveryCode{})
+	db.Where("created_by = ? AND used_by = ?", id, 0).Delete(&users.Invite{})
+
+	// hard delete so the username can be reused
+	return db.Unscoped().Delete(&users.User{}, id).Error
+}
+
+// the :id user
+func paramUser(c echo.Context) (users.User, error) {
+	var user users.User
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return user, echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+	err = db.First(&user, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, echo.NewHTTPError(http.StatusNotFound, "no such user")
+	} else if err != nil {
+		return user, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return user, nil
+}
+
+// the :id user, who must not be the requesting admin
+func otherUser(c echo.Context) (users.User, error) {
+	user, err := paramUser(c)
+	if err != nil {
+		return user, err
+	}
+	if user.ID == c.Get("user_id").(uint) {
+		return user, echo.NewHTTPError(http.StatusBadRequest, "can't modify your own account here")
+	}
+	return user, nil
+}
+
+type userRow struct {
+	users.User
+	Used      string
+	Items     int64
+	QuotaMiB  int64
+	OverQuota bool
+}
+
+func usersHandler(c echo.Context) error {
+	var us []users.User
+	db.Order("id ASC").Find(&us)
+	var rows []userRow
+	for _, u := range us {
+		row := userRow{User: u, QuotaMiB: u.QuotaBytes / 1024 / 1024}
+		if usage, err := quota.GetUsage(u.ID); err == nil {
+			row.Used = handlers.HumanSize(usage.Bytes)
+			row.Items = usage.Items
+			row.OverQuota = quota.CheckDownload(u.ID) != nil
+		}
+		rows = append(rows, row)
+	}
+	var invites []users.Invite
+	db.Where("used_by = ?", 0).Order("id DESC").Find(&invites)
+
+	return c.Render(http.StatusOK, "users.html", map[string]interface{}{
+		"users":   rows,
+		"invites": invites,
+		"self":    c.Get("user_id").(uint),
+		"Footer":  handlers.MakeFooter(),
+	})
+}
+
+func usersPostHandler(c echo.Context) error {
+	username := c.FormValue("username")
+	password := c.FormValue("password")
+	isAdmin := c.FormValue("is_admin") == "on"
+
+	if _, err := users.CreateUser(db, username, password, isAdmin); err != nil {
+		return c.String(http.StatusBadRequest, "Error creating user: "+err.Error())
+	}
+	return c.Redirect(http.StatusSeeOther, "/users")
+}
+
+func userDisableHandler(c echo.Context) error {
+	user, err := otherUser(c)
+	if err != nil {
+		return err
+	}
+	disabled := c.FormValue("disabled") == "true"
+	if err := db.Model(&user).Update("disabled", disabled).Error; err != nil {
+		log.Errorln("couldn't set disabled for user", user.ID, err)
+	}
+	if disabled {
+		users.DeleteSessions(db, user.ID)
+	}
+	return c.Redirect(http.StatusSeeOther, "/users")
+}
+
+func userPasswordHandler(c echo.Context) error {
+	user, err := otherUser(c)
+	if err != nil {
+		return err
+	}
+	if err := users.SetPassword(db, user.ID, c.FormValue("password")); err != nil {
+		return c.String(http.StatusBadRequest, "Error setting password: "+err.Error())
+	}
+	users.DeleteSessions(db, user.ID)
+	return c.Redirect(http.StatusSeeOther, "/users")
+}
+
+func userQuotaHandler(c echo.Context) error {
+	user, err := paramUser(c)
+	if err != nil {
+		return err
+	}
+	quotaMiB, _ := strconv.ParseInt(c.FormValue("quota_mib"), 10, 64)
+	quotaItems, _ := strconv.ParseUint(c.FormValue("quota_items"), 10, 32)
+	err = db.Model(&user).Updates(map[string]interface{}{
+		"quota_bytes": max(quotaMiB, 0) * 1024 * 1024,
+		"quota_items": quotaItems,
+	}).Error
+	if err != nil {
+		log.Errorln("couldn't set quota for user", user.ID, err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/users")
+}
+
+func userDeleteHandler(c echo.Context) error {
+	user, err := otherUser(c)
+	if err != nil {
+		return err
+	}
+	if err := deleteUser(user.ID); err != nil {
+		log.Errorln("couldn't delete user", user.ID, err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/users")
+}
+
+func invitesPostHandler(c echo.Context) error {
+	if _, err := users.CreateInvite(db, c.Get("user_id").(uint), inviteTTL); err != nil {
+		log.Errorln("couldn't create invite", err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/users")
+}
+
+func inviteDeleteHandler(c echo.Context) error {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err := db.Delete(&users.Invite{}, id).Error; err != nil {
+		log.Errorln("couldn't delete invite", id, err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/users")
+}
+
+// GET /api/v1/users
+func apiUsersHandler(c echo.Context) error {
+	us := []users.User{}
+	if err := db.Order("id ASC").Find(&us).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, us)
+}
+
+type apiUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	IsAdmin  bool   `json:"is_admin"`
+}
+
+// POST /api/v1/users
+func apiNewUserHandler(c echo.Context) error {
+	var req apiUserRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	user, err := users.CreateUser(db, req.Username, req.Password, req.IsAdmin)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusCreated, user)
+}
+
+type apiUpdateUserRequest struct {
+	Password   *string `json:"password"`
+	Disabled   *bool   `json:"disabled"`
+	IsAdmin    *bool   `json:"is_admin"`
+	QuotaBytes *int64  `json:"quota_bytes"`
+	QuotaItems *uint   `json:"quota_items"`
+}
+
+// PATCH /api/v1/users/:id
+func apiUpdateUserHandler(c echo.Context) error {
+	user, err := paramUser(c)
+	if err != nil {
+		return err
+	}
+	var req apiUpdateUserRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	// admins may only change their own quota
+	self := user.ID == c.Get("user_id").(uint)
+	if self && (req.Password != nil || req.Disabled != nil || req.IsAdmin != nil) {
+		return echo.NewHTTPError(http.StatusBadRequest, "can't modify your own account here")
+	}
+
+	if req.Password != nil {
+		if err := users.SetPassword(db, user.ID, *req.Password); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		users.DeleteSessions(db, user.ID)
+	}
+	updates := map[string]interface{}{}
+	if req.Disabled != nil {
+		updates["disabled"] = *req.Disabled
+	}
+	if req.IsAdmin != nil {
+		updates["is_admin"] = *req.IsAdmin
+	}
+	if req.QuotaBytes != nil {
+		updates["quota_bytes"] = max(*req.QuotaBytes, 0)
+	}
+	if req.QuotaItems != nil {
+		updates["quota_items"] = *req.QuotaItems
+	}
+	if len(updates) > 0 {
+		if err := db.Model(&user).Updates(updates).Error; err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+	if req.Disabled != nil && *req.Disabled {
+		users.DeleteSessions(db, user.ID)
+	}
+
+	db.First(&user, user.ID)
+	return c.JSON(http.StatusOK, user)
+}
+
+// DELETE /api/v1/users/:id
+func apiDeleteUserHandler(c echo.Context) error {
+	user, err := otherUser(c)
+	if err != nil {
+		return err
+	}
+	if err := deleteUser(user.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GET /api/v1/invites
+func apiInvitesHandler(c echo.Context) error {
+	invites := []users.Invite{}
+	if err := db.Order("id DESC").Find(&invites).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, invites)
+}
+
+// POST /api/v1/invites
+func apiNewInviteHandler(c echo.Context) error {
+	invite, err := users.CreateInvite(db, c.Get("user_id").(uint), inviteTTL)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusCreated, invite)
+}
+
+// DELETE /api/v1/invites/:id
+func apiDeleteInviteHandler(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+	result := db.Delete(&users.Invite{}, id)
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	} else if result.RowsAffected == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "no such invite")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
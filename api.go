@@ -0,0 +1,400 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"ytdlp-site/authz"
+	"ytdlp-site/handlers"
+	"ytdlp-site/media"
+	"ytdlp-site/originals"
+	"ytdlp-site/playlists"
+	"ytdlp-site/profiles"
+	"ytdlp-site/quota"
+	"ytdlp-site/transcodes"
+)
+
+// retrieve the requesting user's original identified by the :id param.
+// errors are echo.HTTPErrors suitable for returning from a handler
+func apiOriginal(c echo.Context) (originals.Original, error) {
+	var orig originals.Original
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return orig, echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+	orig, err = authz.Original(c.Get("user_id").(uint), uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return orig, echo.NewHTTPError(http.StatusNotFound, "no such original")
+	} else if err != nil {
+		return orig, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return orig, nil
+}
+
+type apiSubmitRequest struct {
+	URL       string `json:"url"`
+	Audio     bool   `json:"audio"`      // only download audio
+	Subscribe bool   `json:"subscribe"`  // treat the URL as a channel / playlist subscription
+	ProfileID uint   `json:"profile_id"` // transcode profile (0 for the user's profile)
+	SubLangs  string `json:"sub_langs"`  // subtitle languages, e.g. "en,de" ("" for none)
+}
+
+// POST /api/v1/originals
+func apiSubmitHandler(c echo.Context) error {
+	var req apiSubmitRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if req.URL == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "url is required")
+	}
+	userID := c.Get("user_id").(uint)
+	if req.ProfileID != 0 {
+		if _, err := profiles.Get(db, req.ProfileID); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "no such profile")
+		}
+	}
+
+	subLangs, err := parseSubLangs(req.SubLangs)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if req.Subscribe || isPlaylistUrl(req.URL) {
+		playlist, err := createPlaylist(userID, req.URL, req.Audio, req.Subscribe, req.ProfileID, subLangs)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		return c.JSON(http.StatusCreated, map[string]interface{}{"playlist": playlist})
+	}
+
+	orig, err := createOriginal(userID, req.URL, req.Audio, req.ProfileID, subLangs)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusCreated, map[string]interface{}{"original": orig})
+}
+
+// GET /api/v1/originals
+func apiOriginalsHandler(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	query := db.Where("user_id = ?", userID)
+	if playlistID := c.QueryParam("playlist_id"); playlistID != "" {
+		query = query.Where("playlist = ? AND playlist_id = ?", true, playlistID)
+	}
+	if status := c.QueryParam("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	origs := []originals.Original{}
+	if err := query.Order("id DESC").Find(&origs).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, origs)
+}
+
+// GET /api/v1/originals/:id
+func apiOriginalHandler(c echo.Context) error {
+	orig, err := apiOriginal(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, orig)
+}
+
+// DELETE /api/v1/originals/:id
+func apiDeleteOriginalHandler(c echo.Context) error {
+	orig, err := apiOriginal(c)
+	if err != nil {
+		return err
+	}
+	if err := deleteOriginal(orig.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GET /api/v1/originals/:id/videos
+func apiVideosHandler(c echo.Context) error {
+	orig, err := apiOriginal(c)
+	if err != nil {
+		return err
+	}
+	videos := []media.Video{}
+	if err := db.Where("original_id = ?", orig.ID).Find(&videos).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, videos)
+}
+
+// GET /api/v1/originals/:id/audios
+func apiAudiosHandler(c echo.Context) error {
+	orig, err := apiOriginal(c)
+	if err != nil {
+		return err
+	}
+	audios := []media.Audio{}
+	if err := db.Where("original_id = ?", orig.ID).Find(&audios).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, audios)
+}
+
+// GET /api/v1/originals/:id/clips
+func apiClipsHandler(c echo.Context) error {
+	orig, err := apiOriginal(c)
+	if err != nil {
+		return err
+	}
+	clips := []media.VideoClip{}
+	if err := db.Where("original_id = ?", orig.ID).Find(&clips).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, clips)
+}
+
+// GET /api/v1/originals/:id/audio_clips
+func apiAudioClipsHandler(c echo.Context) error {
+	orig, err := apiOriginal(c)
+	if err != nil {
+		return err
+	}
+	clips := []media.AudioClip{}
+	if err := db.Where("original_id = ?", orig.ID).Find(&clips).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, clips)
+}
+
+type apiClipRequest struct {
+	Kind     string  `json:"kind"`      // "video" or "audio"
+	SourceID uint    `json:"source_id"` // Video.ID or Audio.ID of the original to clip
+	From     float64 `json:"from"`      // seconds
+	To       float64 `json:"to"`        // seconds
+	Precise  bool    `json:"precise"`   // re-encode a video for a frame-accurate cut
+	Title    string  `json:"title"`
+}
+
+// POST /api/v1/originals/:id/clips, the clip is made in the background
+func apiNewClipHandler(c echo.Context) error {
+	orig, err := apiOriginal(c)
+	if err != nil {
+		return err
+	}
+	var req apiClipRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if req.To <= req.From {
+		return echo.NewHTTPError(http.StatusBadRequest, handlers.ErrClipRange.Error())
+	}
+	if err := quota.CheckTranscode(orig.UserID); errors.Is(err, quota.ErrExceeded) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	var src media.MediaFile
+	var run func() error
+	switch req.Kind {
+	case "video":
+		var video media.Video
+		if err := db.Where("id = ? AND original_id = ?", req.SourceID, orig.ID).First(&video).Error; err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "no such video")
+		}
+		src = video.MediaFile
+		run = func() error {
+			_, err := handlers.NewVideoClip(video, req.From, req.To, req.Precise, req.Title)
+			return err
+		}
+	case "audio":
+		var audio media.Audio
+		if err := db.Where("id = ? AND original_id = ?", req.SourceID, orig.ID).First(&audio).Error; err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "no such audio")
+		}
+		src = audio.MediaFile
+		run = func() error {
+			_, err := handlers.NewAudioClip(audio, req.From, req.To, req.Title)
+			return err
+		}
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, `kind must be "video" or "audio"`)
+	}
+	if _, _, err := handlers.ClipRange(src, req.From, req.To); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	go func() {
+		if err := run(); err != nil {
+			log.Errorln("clip of original", orig.ID, err)
+		}
+	}()
+	return c.NoContent(http.StatusAccepted)
+}
+
+// DELETE /api/v1/clips/:id
+func apiDeleteClipHandler(c echo.Context) error {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	clip, err := authz.VideoClip(c.Get("user_id").(uint), uint(id))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "no such clip")
+	}
+	if err := handlers.DeleteVideoClip(clip); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// DELETE /api/v1/audio_clips/:id
+func apiDeleteAudioClipHandler(c echo.Context) error {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	clip, err := authz.AudioClip(c.Get("user_id").(uint), uint(id))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "no such clip")
+	}
+	if err := handlers.DeleteAudioClip(clip); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GET /api/v1/originals/:id/transcodes
+func apiTranscodesHandler(c echo.Context) error {
+	orig, err := apiOriginal(c)
+	if err != nil {
+		return err
+	}
+	trans := []transcodes.Transcode{}
+	if err := db.Where("original_id = ?", orig.ID).Find(&trans).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, trans)
+}
+
+type apiTranscodeRequest struct {
+	Kind   string  `json:"kind"`   // "video" or "audio"
+	Height uint    `json:"height"` // video only
+	FPS    float64 `json:"fps"`    // video only, 0 to keep the source FPS
+	Kbps   uint    `json:"kbps"`   // audio only
+}
+
+// POST /api/v1/originals/:id/transcodes
+func apiNewTranscodeHandler(c echo.Context) error {
+	orig, err := apiOriginal(c)
+	if err != nil {
+		return err
+	}
+	var req apiTranscodeRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	var trans transcodes.Transcode
+	switch req.Kind {
+	case "video":
+		if req.Height == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "height is required")
+		}
+		trans, err = transcodeOriginalToVideo(orig.ID, req.Height, req.FPS)
+	case "audio":
+		if req.Kbps == 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "kbps is required")
+		}
+		trans, err = transcodeOriginalToAudio(orig.ID, req.Kbps)
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, `kind must be "video" or "audio"`)
+	}
+	if errors.Is(err, quota.ErrExceeded) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return c.JSON(http.StatusAccepted, trans)
+}
+
+// GET /api/v1/playlists
+func apiPlaylistsHandler(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	pls := []playlists.Playlist{}
+	if err := db.Where("user_id = ?", userID).Order("id DESC").Find(&pls).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, pls)
+}
+
+// GET /api/v1/playlists/:id
+func apiPlaylistHandler(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+	playlist, err := authz.Playlist(c.Get("user_id").(uint), uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "no such playlist")
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	entries := []originals.Original{}
+	err = db.Where("playlist = ? AND playlist_id = ?", true, playlist.ID).
+		Order("id ASC").Find(&entries).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"playlist": playlist,
+		"entries":  entries,
+	})
+}
+
+func addAPIRoutes(e *echo.Echo) {
+	api := e.Group("/api/v1")
+
+	api.POST("/originals", apiSubmitHandler, handlers.APISubmitAuthMiddleware)
+	api.GET("/originals", apiOriginalsHandler, handlers.APIAuthMiddleware)
+	api.GET("/originals/:id", apiOriginalHandler, handlers.APIAuthMiddleware)
+	api.DELETE("/originals/:id", apiDeleteOriginalHandler, handlers.APIAuthMiddleware)
+	api.GET("/originals/:id/videos", apiVideosHandler, handlers.APIAuthMiddleware)
+	api.GET("/originals/:id/audios", apiAudiosHandler, handlers.APIAuthMiddleware)
+	api.GET("/originals/:id/clips", apiClipsHandler, handlers.APIAuthMiddleware)
+	api.POST("/originals/:id/clips", apiNewClipHandler, handlers.APIAuthMiddleware)
+	api.GET("/originals/:id/audio_clips", apiAudioClipsHandler, handlers.APIAuthMiddleware)
+	api.DELETE("/clips/:id", apiDeleteClipHandler, handlers.APIAuthMiddleware)
+	api.DELETE("/audio_clips/:id", apiDeleteAudioClipHandler, handlers.APIAuthMiddleware)
+	api.GET("/originals/:id/subtitles", apiSubtitlesHandler, handlers.APIAuthMiddleware)
+	api.GET("/originals/:id/chapters", apiChaptersHandler, handlers.APIAuthMiddleware)
+	api.POST("/originals/:id/chapters/split", apiSplitChaptersHandler, handlers.APIAuthMiddleware)
+	api.GET("/originals/:id/transcodes", apiTranscodesHandler, handlers.APIAuthMiddleware)
+	api.POST("/originals/:id/transcodes", apiNewTranscodeHandler, handlers.APIAuthMiddleware)
+	api.GET("/originals/:id/hls", apiHLSHandler, handlers.APIAuthMiddleware)
+	api.POST("/originals/:id/hls", apiBuildHLSHandler, handlers.APIAuthMiddleware)
+	api.GET("/search", apiSearchHandler, handlers.APIAuthMiddleware)
+	api.GET("/playlists", apiPlaylistsHandler, handlers.APIAuthMiddleware)
+	api.GET("/playlists/:id", apiPlaylistHandler, handlers.APIAuthMiddleware)
+	api.GET("/usage", handlers.APIUsageGet, handlers.APIAuthMiddleware)
+	api.GET("/tokens", handlers.APITokensGet, handlers.APIAuthMiddleware)
+	api.POST("/tokens", handlers.APITokensPost, handlers.APIAuthMiddleware)
+	api.DELETE("/tokens/:id", handlers.APITokenDelete, handlers.APIAuthMiddleware)
+	api.GET("/profiles", apiProfilesHandler, handlers.APIAuthMiddleware)
+
+	admin := []echo.MiddlewareFunc{handlers.APIAuthMiddleware, handlers.APIAdminMiddleware}
+	api.GET("/users", apiUsersHandler, admin...)
+	api.POST("/users", apiNewUserHandler, admin...)
+	api.PATCH("/users/:id", apiUpdateUserHandler, admin...)
+	api.DELETE("/users/:id", apiDeleteUserHandler, admin...)
+	api.GET("/invites", apiInvitesHandler, admin...)
+	api.POST("/invites", apiNewInviteHandler, admin...)
+	api.DELETE("/invites/:id", apiDeleteInviteHandler, admin...)
+	api.GET("/reconcile", apiReconcileHandler, admin...)
+	api.POST("/reconcile", apiRunReconcileHandler, admin...)
+	api.POST("/profiles", apiNewProfileHandler, admin...)
+	api.PATCH("/profiles/:id", apiUpdateProfileHandler, admin...)
+	api.DELETE("/profiles/:id", apiDeleteProfileHandler, admin...)
+	api.POST("/profiles/:id/renditions", apiNewRenditionHandler, admin...)
+	api.DELETE("/profiles/:id/renditions/:rid", apiDeleteRenditionHandler, admin...)
+}
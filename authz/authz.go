@@ -0,0 +1,89 @@
+// Package authz scopes database lookups to the records a user owns.
+//
+// Every lookup returns gorm.ErrRecordNotFound for records that belong to
+// another user, so callers can't tell a foreign record from a missing one.
+package authz
+
+import (
+	"gorm.io/gorm"
+
+	"ytdlp-site/database"
+	"ytdlp-site/media"
+	"ytdlp-site/originals"
+	"ytdlp-site/playlists"
+	"ytdlp-site/transcodes"
+)
+
+// Owner restricts a query on a table with a user_id column
+// (originals, playlists) to the user's rows
+func Owner(userID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ?", userID)
+	}
+}
+
+// Media restricts a query on a table with an original_id column
+// (videos, audios, clips, transcodes) to rows of the user's originals
+func Media(userID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("original_id IN (?)",
+			database.Get().Model(&originals.Original{}).Select("id").Where("user_id = ?", userID))
+	}
+}
+
+func first[T any](scope func(*gorm.DB) *gorm.DB, id uint) (T, error) {
+	var t T
+	err := database.Get().Scopes(scope).Where("id = ?", id).First(&t).Error
+	return t, err
+}
+
+func Original(userID, id uint) (originals.Original, error) {
+	return first[originals.Original](Owner(userID), id)
+}
+
+func Playlist(userID, id uint) (playlists.Playlist, error) {
+	return first[playlists.Playlist](Owner(userID), id)
+}
+
+func Video(userID, id uint) (media.Video, error) {
+	return first[media.Video](Media(userID), id)
+}
+
+func Audio(userID, id uint) (media.Audio, error) {
+	return first[media.Audio](Media(userID), id)
+}
+
+func VideoClip(userID, id uint) (media.VideoClip, error) {
+	return first[media.VideoClip](Media(userID), id)
+}
+
+func AudioClip(userID, id uint) (media.AudioClip, error) {
+	return first[media.AudioClip](Media(userID), id)
+}
+
+func Subtitle(userID, id uint) (media.Subtitle, error) {
+	return first[media.Subtitle](Media(userID), id)
+}
+
+func HLS(userID, id uint) (media.HLS, error) {
+	return first[media.HLS](Media(userID), id)
+}
+
+func Transcode(userID, id uint) (transcodes.Transcode, error) {
+	return first[transcodes.Transcode](Media(userID), id)
+}
+
+// OwnsFile reports whether filename (relative to the data directory)
+// belongs to one of the user's media records
+func OwnsFile(userID uint, filename string) bool {
+	db := database.Get()
+	for _, model := range []interface{}{&media.Video{}, &media.Audio{}, &media.VideoClip{}, &media.AudioClip{}, &media.Subtitle{}} {
+		var count int64
+		err := db.Model(model).Scopes(Media(userID)).
+			Where("filename = ?", filename).Count(&count).Error
+		if err == nil && count > 0 {
+			return true
+		}
+	}
+	return false
+}
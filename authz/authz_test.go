@@ -0,0 +1,161 @@
+package authz
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"ytdlp-site/database/dbtest"
+	"ytdlp-site/media"
+	"ytdlp-site/originals"
+	"ytdlp-site/playlists"
+	"ytdlp-site/transcodes"
+)
+
+// the records one user owns
+type fixture struct {
+	userID    uint
+	original  originals.Original
+	playlist  playlists.Playlist
+	video     media.Video
+	audio     media.Audio
+	videoClip media.VideoClip
+	audioClip media.AudioClip
+	subtitle  media.Subtitle
+	transcode transcodes.Transcode
+}
+
+// the tables the fixtures need
+var models = []interface{}{&originals.Original{}, &playlists.Playlist{},
+	&media.Video{}, &media.Audio{}, &media.VideoClip{}, &media.AudioClip{}, &media.Subtitle{},
+	&media.HLS{}, &transcodes.Transcode{}}
+
+func createFixture(t *testing.T, db *gorm.DB, userID uint) fixture {
+	t.Helper()
+	name := func(kind string) string {
+		return fmt.Sprintf("user%d-%s", userID, kind)
+	}
+
+	f := fixture{userID: userID}
+	f.playlist = playlists.Playlist{UserID: userID, Title: name("playlist")}
+	mustCreate(t, db, &f.playlist)
+	f.original = originals.Original{UserID: userID, Title: name("original"),
+		Playlist: true, PlaylistID: f.playlist.ID}
+	mustCreate(t, db, &f.original)
+
+	f.video = media.Video{OriginalID: f.original.ID}
+	f.video.Filename = name("video.mp4")
+	mustCreate(t, db, &f.video)
+	f.audio = media.Audio{OriginalID: f.original.ID, MediaFile: media.MediaFile{Filename: name("audio.m4a")}}
+	mustCreate(t, db, &f.audio)
+	f.videoClip = media.VideoClip{OriginalID: f.original.ID, VideoID: f.video.ID}
+	f.videoClip.Filename = name("clip.mp4")
+	mustCreate(t, db, &f.videoClip)
+	f.audioClip = media.AudioClip{OriginalID: f.original.ID, AudioID: f.audio.ID,
+		MediaFile: media.MediaFile{Filename: name("clip.m4a")}}
+	mustCreate(t, db, &f.audioClip)
+	f.subtitle = media.Subtitle{OriginalID: f.original.ID, Lang: "en",
+		MediaFile: media.MediaFile{Filename: name("sub.vtt")}}
+	mustCreate(t, db, &f.subtitle)
+	f.transcode = transcodes.Transcode{OriginalID: f.original.ID, SrcID: f.video.ID,
+		SrcKind: "video", DstKind: "video", Status: "pending"}
+	mustCreate(t, db, &f.transcode)
+	return f
+}
+
+func mustCreate(t *testing.T, db *gorm.DB, value interface{}) {
+	t.Helper()
+	if err := db.Create(value).Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLookupsDenyOtherUsers(t *testing.T) {
+	db := dbtest.Open(t, models...)
+	alice := createFixture(t, db, 1)
+	bob := createFixture(t, db, 2)
+
+	// look up each of owner's records as userID
+	lookups := []struct {
+		name   string
+		lookup func(userID uint, owner fixture) error
+	}{
+		{"Original", func(userID uint, owner fixture) error {
+			_, err := Original(userID, owner.original.ID)
+			return err
+		}},
+		{"Playlist", func(userID uint, owner fixture) error {
+			_, err := Playlist(userID, owner.playlist.ID)
+			return err
+		}},
+		{"Video", func(userID uint, owner fixture) error {
+			_, err := Video(userID, owner.video.ID)
+			return err
+		}},
+		{"Audio", func(userID uint, owner fixture) error {
+			_, err := Audio(userID, owner.audio.ID)
+			return err
+		}},
+		{"VideoClip", func(userID uint, owner fixture) error {
+			_, err := VideoClip(userID, owner.videoClip.ID)
+			return err
+		}},
+		{"AudioClip", func(userID uint, owner fixture) error {
+			_, err := AudioClip(userID, owner.audioClip.ID)
+			return err
+		}},
+		{"Subtitle", func(userID uint, owner fixture) error {
+			_, err := Subtitle(userID, owner.subtitle.ID)
+			return err
+		}},
+		{"Transcode", func(userID uint, owner fixture) error {
+			_, err := Transcode(userID, owner.transcode.ID)
+			return err
+		}},
+	}
+
+	for _, tc := range lookups {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, owner := range []fixture{alice, bob} {
+				if err := tc.lookup(owner.userID, owner); err != nil {
+					t.Errorf("user %d can't look up their own record: %v", owner.userID, err)
+				}
+			}
+			if err := tc.lookup(bob.userID, alice); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Errorf("user %d looked up user %d's record: err = %v", bob.userID, alice.userID, err)
+			}
+			if err := tc.lookup(alice.userID, bob); !errors.Is(err, gorm.ErrRecordNotFound) {
+				t.Errorf("user %d looked up user %d's record: err = %v", alice.userID, bob.userID, err)
+			}
+		})
+	}
+}
+
+func TestOwnsFile(t *testing.T) {
+	db := dbtest.Open(t, models...)
+	alice := createFixture(t, db, 1)
+	bob := createFixture(t, db, 2)
+
+	files := func(f fixture) []string {
+		return []string{f.video.Filename, f.audio.Filename, f.videoClip.Filename,
+			f.audioClip.Filename, f.subtitle.Filename}
+	}
+	for _, name := range files(alice) {
+		if !OwnsFile(alice.userID, name) {
+			t.Errorf("user %d doesn't own their file %s", alice.userID, name)
+		}
+		if OwnsFile(bob.userID, name) {
+			t.Errorf("user %d owns user %d's file %s", bob.userID, alice.userID, name)
+		}
+	}
+	for _, name := range files(bob) {
+		if OwnsFile(alice.userID, name) {
+			t.Errorf("user %d owns user %d's file %s", alice.userID, bob.userID, name)
+		}
+	}
+	if OwnsFile(alice.userID, "config/videos.db") {
+		t.Error("a file without a media record is owned")
+	}
+}
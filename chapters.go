@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+
+	"ytdlp-site/authz"
+	"ytdlp-site/handlers"
+	"ytdlp-site/media"
+	"ytdlp-site/originals"
+	"ytdlp-site/quota"
+)
+
+var errNoChapters = errors.New("no chapters")
+var errNoClipSource = errors.New("no downloaded video or audio to split")
+
+// serializes chapter splits, so splitting twice doesn't clip a chapter twice
+var splitMu sync.Mutex
+
+// a chapter as shown on the video page
+type DisplayChapter struct {
+	Title string
+	Start string // h:mm:ss
+	Secs  float64
+}
+
+func displayChapters(chapters []originals.Chapter) []DisplayChapter {
+	var display []DisplayChapter
+	for _, ch := range chapters {
+		secs := float64(ch.StartMS) / 1000
+		display = append(display, DisplayChapter{
+			Title: ch.Title,
+			Start: humanLength(secs),
+			Secs:  secs,
+		})
+	}
+	return display
+}
+
+// clip every chapter of an original out of its downloaded video, or its
+// downloaded audio for audio-only originals. Chapters that already have a
+// clip are skipped
+func splitChapters(orig originals.Original) (int, error) {
+	splitMu.Lock()
+	defer splitMu.Unlock()
+
+	chapters, err := originals.GetChapters(orig.ID)
+	if err != nil {
+		return 0, err
+	}
+	if len(chapters) == 0 {
+		return 0, errNoChapters
+	}
+
+	var video media.Video
+	var audio media.Audio
+	if err := db.Where("original_id = ? AND source = ?", orig.ID, "original").First(&video).Error; err != nil {
+		if err := db.Where("original_id = ? AND source = ?", orig.ID, "original").First(&audio).Error; err != nil {
+			return 0, errNoClipSource
+		}
+	}
+
+	created := 0
+	for _, ch := range chapters {
+		var count int64
+		if video.ID != 0 {
+			db.Model(&media.VideoClip{}).
+				Where("video_id = ? AND start_ms = ? AND stop_ms = ?", video.ID, ch.StartMS, ch.StopMS).
+				Count(&count)
+		} else {
+			db.Model(&media.AudioClip{}).
+				Where("audio_id = ? AND start_ms = ? AND stop_ms = ?", audio.ID, ch.StartMS, ch.StopMS).
+				Count(&count)
+		}
+		if count > 0 {
+			continue
+		}
+
+		from, to := float64(ch.StartMS)/1000, float64(ch.StopMS)/1000
+		if video.ID != 0 {
+			_, err = handlers.NewVideoClip(video, from, to, false, ch.Title)
+		} else {
+			_, err = handlers.NewAudioClip(audio, from, to, ch.Title)
+		}
+		if errors.Is(err, handlers.ErrClipRange) {
+			continue // chapter past the end of the download
+		} else if err != nil {
+			return created, fmt.Errorf("clip chapter %d: %w", ch.Index, err)
+		}
+		created += 1
+	}
+	log.Infof("split original %d into %d new chapter clips", orig.ID, created)
+	return created, nil
+}
+
+// POST /video/:id/chapters/split
+func splitChaptersHandler(c echo.Context) error {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	orig, err := authz.Original(c.Get("user_id").(uint), uint(id))
+	if err != nil {
+		return c.String(http.StatusNotFound, "no such original")
+	}
+	if err := quota.CheckTranscode(orig.UserID); errors.Is(err, quota.ErrExceeded) {
+		return c.String(http.StatusForbidden, err.Error())
+	} else if err != nil {
+		log.Errorln(err)
+	}
+	go func() {
+		if _, err := splitChapters(orig); err != nil {
+			log.Errorln("split chapters of original", orig.ID, err)
+		}
+	}()
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/video/%d", orig.ID))
+}
+
+// GET /api/v1/originals/:id/chapters
+func apiChaptersHandler(c echo.Context) error {
+	orig, err := apiOriginal(c)
+	if err != nil {
+		return err
+	}
+	chapters, err := originals.GetChapters(orig.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if chapters == nil {
+		chapters = []originals.Chapter{}
+	}
+	return c.JSON(http.StatusOK, chapters)
+}
+
+// POST /api/v1/originals/:id/chapters/split
+func apiSplitChaptersHandler(c echo.Context) error {
+	orig, err := apiOriginal(c)
+	if err != nil {
+		return err
+	}
+	if err := quota.CheckTranscode(orig.UserID); errors.Is(err, quota.ErrExceeded) {
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	created, err := splitChapters(orig)
+	if errors.Is(err, errNoChapters) || errors.Is(err, errNoClipSource) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]int{"created": created})
+}
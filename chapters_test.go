@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"ytdlp-site/users"
+)
+
+func TestSplitChaptersQuotaExceeded(t *testing.T) {
+	e := setupTestServer(t)
+	alice := createTestUser(t, "alice")
+	db.Model(&alice.video).Update("size", 100)
+	db.Model(&users.User{}).Where("id = ?", alice.user.ID).Update("quota_bytes", 100)
+
+	for _, target := range []string{
+		fmt.Sprintf("/video/%d/chapters/split", alice.original.ID),
+		fmt.Sprintf("/api/v1/originals/%d/chapters/split", alice.original.ID),
+	} {
+		if rec := serve(e, alice, http.MethodPost, target); rec.Code != http.StatusForbidden {
+			t.Errorf("POST %s over quota: status %d, want %d", target, rec.Code, http.StatusForbidden)
+		}
+	}
+}
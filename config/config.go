@@ -2,8 +2,10 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -27,6 +29,11 @@ func GetConfigDir() string {
 	return filepath.Join(GetDataDir(), "config")
 }
 
+// the SQLite database, in GetConfigDir()
+func GetDatabasePath() string {
+	return filepath.Join(GetConfigDir(), "videos.db")
+}
+
 func GetAdminInitialPassword() (string, error) {
 	key := "YTDLP_SITE_ADMIN_INITIAL_PASSWORD"
 	value, exists := os.LookupEnv(key)
@@ -56,6 +63,56 @@ func GetSecure() bool {
 	return false
 }
 
+// downloads and transcodes are deferred while the data directory has less free space than this.
+// 0 disables the check
+func GetMinFreeBytes() int64 {
+	key := "YTDLP_SITE_MIN_FREE_MB"
+	if value, exists := os.LookupEnv(key); exists {
+		if mb, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64); err == nil && mb >= 0 {
+			return mb * 1024 * 1024
+		}
+	}
+	return 1024 * 1024 * 1024
+}
+
+// what the scheduled reconciler does with orphan files and dangling records:
+// "report" (default), "delete" or "quarantine"
+func GetReconcileMode() string {
+	return os.Getenv("YTDLP_SITE_RECONCILE_MODE")
+}
+
+// name of a header set by a trusted reverse proxy to the authenticated username.
+// Empty if forward-auth is disabled
+func GetAuthHeader() string {
+	return strings.TrimSpace(os.Getenv("YTDLP_SITE_AUTH_HEADER"))
+}
+
+// networks of the reverse proxies allowed to set GetAuthHeader()
+func GetTrustedProxies() ([]*net.IPNet, error) {
+	key := "YTDLP_SITE_TRUSTED_PROXIES"
+	var nets []*net.IPNet
+	for _, s := range strings.Split(os.Getenv(key), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		// allow bare addresses
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
 func GetGitSHA() string {
 	if gitSHA == "" {
 		return "<not provided>"
@@ -0,0 +1,105 @@
+// Package diskspace watches the free space of the data directory so that
+// downloads and transcodes can be deferred instead of failing mid-write.
+package diskspace
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"ytdlp-site/config"
+)
+
+var mu sync.Mutex
+var low bool
+var free int64
+var resumed = make(chan struct{}) // closed while there is enough space
+
+func init() {
+	close(resumed)
+}
+
+// Free returns the free space in bytes for the filesystem containing the given directory
+func Free(dir string) (int64, error) {
+	var stat unix.Statfs_t
+	err := unix.Statfs(dir, &stat)
+	if err != nil {
+		return 0, fmt.Errorf("error getting filesystem stats: %v", err)
+	}
+
+	// Calculate free space
+	freeSpace := int64(stat.Bavail) * int64(stat.Bsize)
+	return freeSpace, nil
+}
+
+// Low reports whether free space was below the threshold at the last Update
+func Low() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return low
+}
+
+// LastFree returns the free space seen by the last Update
+func LastFree() int64 {
+	mu.Lock()
+	defer mu.Unlock()
+	return free
+}
+
+// Update checks the free space of the data directory and returns whether it is low.
+// If the free space can't be determined, the previous state is kept
+func Update() bool {
+	threshold := config.GetMinFreeBytes()
+	f, err := Free(config.GetDataDir())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if err != nil {
+		log.Errorln(err)
+		return low
+	}
+	free = f
+
+	wasLow := low
+	low = threshold > 0 && free < threshold
+	if low && !wasLow {
+		log.Warnf("free space %d MiB is below %d MiB, deferring downloads and transcodes",
+			free/1024/1024, threshold/1024/1024)
+		resumed = make(chan struct{})
+	} else if !low && wasLow {
+		log.Infof("free space %d MiB, resuming downloads and transcodes", free/1024/1024)
+		close(resumed)
+	}
+	return low
+}
+
+// Wait blocks until there is enough free space or timeout elapses,
+// and returns whether there is enough space
+func Wait(timeout time.Duration) bool {
+	mu.Lock()
+	ch := resumed
+	mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+	case <-timer.C:
+	}
+	return !Update()
+}
+
+// Monitor checks free space every interval, calling onResume when space frees up
+func Monitor(interval time.Duration, onResume func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		wasLow := Low()
+		if !Update() && wasLow {
+			onResume()
+		}
+		<-ticker.C
+	}
+}
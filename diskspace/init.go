@@ -0,0 +1,14 @@
+package diskspace
+
+import "github.com/sirupsen/logrus"
+
+var log *logrus.Logger
+
+func Init(logger *logrus.Logger) error {
+	log = logger.WithFields(logrus.Fields{
+		"component": "diskspace",
+	}).Logger
+	return nil
+}
+
+func Fini() {}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"ytdlp-site/diskspace"
+	"ytdlp-site/downloads"
+	"ytdlp-site/jobs"
+	"ytdlp-site/originals"
+	"ytdlp-site/playlists"
+	"ytdlp-site/quota"
+)
+
+const (
+	maxConcurrentDownloads = 2
+	maxDownloadAttempts    = 3
+	downloadRetryDelay     = 5 * time.Minute
+)
+
+// wakes an idle download worker
+var downloadWake = make(chan struct{}, 1)
+
+// serializes claiming of pending download jobs between workers
+var claimMu sync.Mutex
+
+func wakeDownloadWorkers() {
+	select {
+	case downloadWake <- struct{}{}:
+	default:
+	}
+}
+
+// queue a download job for an original, replacing any existing job
+func newDownload(originalID uint) error {
+	deleteDownloads(originalID)
+
+	now := time.Now()
+	dl := downloads.Download{
+		OriginalID: originalID,
+		Status:     downloads.StatusPending,
+		NotBefore:  now,
+		TimeSubmit: now,
+	}
+	if err := db.Create(&dl).Error; err != nil {
+		return err
+	}
+	originals.SetStatus(originalID, originals.StatusNotStarted)
+	wakeDownloadWorkers()
+	return nil
+}
+
+func deleteDownloads(originalID uint) {
+	log.Debugln("Delete Download entries for Original", originalID)
+	db.Delete(&downloads.Download{}, "original_id = ?", originalID)
+}
+
+// mark the next pending download job as running and return it
+func claimDownload() (downloads.Download, bool) {
+	claimMu.Lock()
+	defer claimMu.Unlock()
+
+	var dl downloads.Download
+	err := db.Where("status = ? AND not_before <= ?", downloads.StatusPending, time.Now()).
+		Order("id ASC").First(&dl).Error
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			log.Errorln("error retrieving pending download:", err)
+		}
+		return dl, false
+	}
+
+	dl.Status = downloads.StatusRunning
+	dl.Attempts += 1
+	dl.TimeStart = time.Now()
+	err = db.Model(&dl).Updates(map[string]interface{}{
+		"status":     dl.Status,
+		"attempts":   dl.Attempts,
+		"time_start": dl.TimeStart,
+	}).Error
+	if err != nil {
+		log.Errorln("error claiming download", dl.ID, err)
+		return dl, false
+	}
+	// registered while claimMu is held, so a cancel can't miss a claimed job
+	jobs.Register(jobs.Download, dl.OriginalID, func() {})
+	return dl, true
+}
+
+func runDownload(dl downloads.Download) {
+	defer jobs.Unregister(jobs.Download, dl.OriginalID)
+
+	var orig originals.Original
+	if err := db.First(&orig, dl.OriginalID).Error; err != nil {
+		log.Errorln("no original for download", dl.ID, err)
+		db.Delete(&dl)
+		return
+	}
+
+	log.Debugf("download %d for original %d attempt %d/%d", dl.ID, orig.ID, dl.Attempts, maxDownloadAttempts)
+	defer updatePlaylistStatus(orig)
+
+	if err := quota.CheckDownload(orig.UserID); err != nil {
+		log.Infoln("not downloading original", orig.ID, err)
+		db.Delete(&dl)
+		if errors.Is(err, quota.ErrExceeded) {
+			originals.SetStatus(orig.ID, originals.StatusQuotaExceeded)
+		} else {
+			originals.SetStatus(orig.ID, originals.StatusFailed)
+		}
+		return
+	}
+
+	err := startDownload(orig.ID, orig.URL, orig.Audio)
+	if errors.Is(err, context.Canceled) || jobs.Cancelled(jobs.Download, orig.ID) {
+		log.Infoln("download", dl.ID, "for original", orig.ID, "cancelled")
+		db.Delete(&dl)
+		originals.SetStatus(orig.ID, originals.StatusCancelled)
+		return
+	}
+	if err == nil {
+		db.Delete(&dl)
+		return
+	}
+	log.Errorf("download %d for original %d failed: %v", dl.ID, orig.ID, err)
+
+	if dl.Attempts < maxDownloadAttempts {
+		// back off so we don't hammer the upstream site
+		notBefore := time.Now().Add(time.Duration(dl.Attempts) * downloadRetryDelay)
+		db.Model(&dl).Updates(map[string]interface{}{
+			"status":     downloads.StatusPending,
+			"not_before": notBefore,
+		})
+		originals.SetStatus(orig.ID, originals.StatusNotStarted)
+	} else {
+		db.Model(&dl).Update("status", downloads.StatusFailed)
+		originals.SetStatus(orig.ID, originals.StatusFailed)
+	}
+}
+
+// cancel a pending or running download for an original. A download that has
+// finished and is queuing its transcodes can't be cancelled.
+// Returns false if there was no download to cancel
+func cancelDownload(originalID uint) bool {
+	claimMu.Lock()
+	defer claimMu.Unlock()
+
+	// a pending job hasn't been claimed by a worker, so it can just be dropped
+	result := db.Where("original_id = ? AND status = ?", originalID, downloads.StatusPending).
+		Delete(&downloads.Download{})
+	if result.Error != nil {
+		log.Errorln("error deleting download for original", originalID, result.Error)
+	}
+
+	// kill yt-dlp if it is running, the worker cleans up the job and the
+	// temporary directory
+	running := jobs.Cancel(jobs.Download, originalID)
+	if result.RowsAffected == 0 && !running {
+		return false
+	}
+	originals.SetStatus(originalID, originals.StatusCancelled)
+	return true
+}
+
+// roll the status of a playlist entry up into its playlist
+func updatePlaylistStatus(orig originals.Original) {
+	if !orig.Playlist {
+		return
+	}
+	if err := playlists.UpdateStatus(orig.PlaylistID); err != nil {
+		log.Errorln("couldn't update status for playlist", orig.PlaylistID, err)
+	}
+}
+
+func downloadWorker() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		for {
+			// leave jobs pending until there is room for them
+			if diskspace.Update() {
+				break
+			}
+			dl, ok := claimDownload()
+			if !ok {
+				break
+			}
+			// there may be more work for another idle worker
+			wakeDownloadWorkers()
+			runDownload(dl)
+		}
+
+		select {
+		case <-downloadWake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func startDownloadWorkers() {
+	for i := 0; i < maxConcurrentDownloads; i++ {
+		go downloadWorker()
+	}
+}
+
+func cleanupDownloads() {
+	log.Traceln("cleanupDownloads")
+
+	// any running jobs here were interrupted, so reset them
+	db.Model(&downloads.Download{}).
+		Where("status = ?", downloads.StatusRunning).
+		Update("status", downloads.StatusPending)
+
+	// originals that were mid-download without a job -> queue a job
+	var stuck []uint
+	db.Model(&originals.Original{}).
+		Select("id").
+		Where("status IN ? AND id NOT IN (?)",
+			[]originals.Status{originals.StatusMetadata, originals.StatusDownloading},
+			db.Model(&downloads.Download{}).Select("original_id"),
+		).
+		Find(&stuck)
+	for _, id := range stuck {
+		log.Debugln("queue download for interrupted original", id)
+		if err := newDownload(id); err != nil {
+			log.Errorln("couldn't queue download for original", id, err)
+		}
+	}
+
+	// originals with a pending job -> not started
+	db.Model(&originals.Original{}).
+		Where("id IN (?)",
+			db.Model(&downloads.Download{}).
+				Select("original_id").
+				Where("status = ?", downloads.StatusPending),
+		).
+		Update("status", originals.StatusNotStarted)
+}
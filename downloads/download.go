@@ -0,0 +1,25 @@
+package downloads
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Status string
+
+const (
+	StatusPending Status = "pending"
+	StatusRunning Status = "running"
+	StatusFailed  Status = "failed"
+)
+
+type Download struct {
+	gorm.Model
+	OriginalID uint // Original.ID
+	Status     Status
+	Attempts   uint      // number of times this download has been started
+	NotBefore  time.Time // don't start the download before this time
+	TimeSubmit time.Time
+	TimeStart  time.Time
+}
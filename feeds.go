@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"ytdlp-site/authz"
+	"ytdlp-site/config"
+	"ytdlp-site/handlers"
+	"ytdlp-site/media"
+	"ytdlp-site/originals"
+	"ytdlp-site/playlists"
+	"ytdlp-site/users"
+)
+
+// most recent originals listed in a feed
+const feedMaxItems = 200
+
+const itunesNS = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Itunes  string     `xml:"xmlns:itunes,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Author      string    `xml:"itunes:author"`
+	Explicit    string    `xml:"itunes:explicit"`
+	Block       string    `xml:"itunes:block"` // keep private feeds out of directories
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link,omitempty"`
+	Description string       `xml:"description"`
+	GUID        rssGUID      `xml:"guid"`
+	PubDate     string       `xml:"pubDate"`
+	Enclosure   rssEnclosure `xml:"enclosure"`
+	Author      string       `xml:"itunes:author,omitempty"`
+	Duration    int64        `xml:"itunes:duration,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// the audio rendition of an original to put in a feed: the highest
+// bitrate transcode, or the downloaded audio if there are none
+func feedAudio(originalID uint) (media.Audio, error) {
+	var audio media.Audio
+	err := db.Where("original_id = ?", originalID).
+		Order("CASE WHEN source = 'transcode' THEN 0 ELSE 1 END, bps DESC").
+		First(&audio).Error
+	return audio, err
+}
+
+// feed items for originals that have audio
+func feedItems(c echo.Context, key string, origs []originals.Original) []rssItem {
+	items := []rssItem{}
+	for _, orig := range origs {
+		audio, err := feedAudio(orig.ID)
+		if err != nil {
+			continue
+		}
+		typ := audio.Type
+		if typ == "" {
+			typ = media.TypeFor(audio.Filename, false)
+		}
+		name := url.PathEscape(makeNiceFilename(orig.Title) + path.Ext(audio.Filename))
+		items = append(items, rssItem{
+			Title:       orig.Title,
+			Link:        orig.URL,
+			Description: orig.URL,
+			GUID:        rssGUID{Value: fmt.Sprintf("ytdlp-site-original-%d", orig.ID)},
+			PubDate:     orig.CreatedAt.Format(time.RFC1123Z),
+			Enclosure: rssEnclosure{
+				URL:    fmt.Sprintf("%s/feed/%s/audio/%d/%s", handlers.SiteURL(c), key, audio.ID, name),
+				Length: audio.Size,
+				Type:   typ,
+			},
+			Author:   orig.Artist,
+			Duration: int64(audio.Length + 0.5),
+		})
+	}
+	return items
+}
+
+// originals with audio renditions, newest first
+func feedOriginals(query func(db *gorm.DB) *gorm.DB) ([]originals.Original, error) {
+	var origs []originals.Original
+	err := query(db.Model(&originals.Original{})).
+		Where("id IN (?)", db.Model(&media.Audio{}).Select("original_id")).
+		Order("created_at DESC").
+		Limit(feedMaxItems).
+		Find(&origs).Error
+	return origs, err
+}
+
+func renderFeed(c echo.Context, channel rssChannel) error {
+	out, err := xml.MarshalIndent(rss{Version: "2.0", Itunes: itunesNS, Channel: channel}, "", "  ")
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	return c.Blob(http.StatusOK, "application/rss+xml; charset=utf-8", append([]byte(xml.Header), out...))
+}
+
+// the user whose feed key is in the URL
+func feedUser(c echo.Context) (users.User, error) {
+	user, err := users.LookupFeedKey(db, c.Param("key"))
+	if err != nil {
+		return user, echo.ErrNotFound
+	}
+	return user, nil
+}
+
+// GET /feed/:key/audio, all of a user's originals with audio
+func userFeedHandler(c echo.Context) error {
+	user, err := feedUser(c)
+	if err != nil {
+		return err
+	}
+	origs, err := feedOriginals(func(q *gorm.DB) *gorm.DB {
+		return q.Where("user_id = ?", user.ID)
+	})
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	return renderFeed(c, rssChannel{
+		Title:       fmt.Sprintf("%s's downloads", user.Username),
+		Link:        handlers.SiteURL(c) + "/videos",
+		Description: fmt.Sprintf("Audio downloaded by %s", user.Username),
+		Author:      user.Username,
+		Explicit:    "false",
+		Block:       "Yes",
+		Items:       feedItems(c, c.Param("key"), origs),
+	})
+}
+
+// GET /feed/:key/playlists/:id, the entries of a playlist with audio
+func playlistFeedHandler(c echo.Context) error {
+	user, err := feedUser(c)
+	if err != nil {
+		return err
+	}
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	playlist, err := authz.Playlist(user.ID, uint(id))
+	if err != nil {
+		return echo.ErrNotFound
+	}
+	origs, err := feedOriginals(func(q *gorm.DB) *gorm.DB {
+		return q.Where("playlist = ? AND playlist_id = ?", true, playlist.ID)
+	})
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+	return renderFeed(c, rssChannel{
+		Title:       playlistTitle(playlist),
+		Link:        fmt.Sprintf("%s/p/%d", handlers.SiteURL(c), playlist.ID),
+		Description: playlist.URL,
+		Author:      user.Username,
+		Explicit:    "false",
+		Block:       "Yes",
+		Items:       feedItems(c, c.Param("key"), origs),
+	})
+}
+
+func playlistTitle(playlist playlists.Playlist) string {
+	if playlist.Title != "" {
+		return playlist.Title
+	}
+	return playlist.URL
+}
+
+// GET /feed/:key/audio/:id/:name, a feed enclosure. name is only there for podcast apps
+func feedAudioHandler(c echo.Context) error {
+	user, err := feedUser(c)
+	if err != nil {
+		return err
+	}
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	audio, err := authz.Audio(user.ID, uint(id))
+	if err != nil {
+		return echo.ErrNotFound
+	}
+	return c.File(filepath.Join(config.GetDataDir(), audio.Filename))
+}
@@ -4,15 +4,59 @@ import (
 	"bytes"
 	"fmt"
 	"os/exec"
+	"path/filepath"
 	"strings"
 )
 
-func Clip(src, dst string, from, to float64) error {
-	_, _, err := Ffmpeg("-i", src,
+// a video encoder and its settings for re-encoded clips
+type clipEncoder struct {
+	codec string
+	args  []string
+}
+
+// re-encoded clips by container, chosen explicitly so the result doesn't
+// depend on which encoders this ffmpeg build prefers
+var clipEncoders = map[string]clipEncoder{
+	".webm": {"vp9", []string{"-c:v", "libvpx-vp9", "-crf", "31", "-b:v", "0", "-row-mt", "1",
+		"-deadline", "good", "-cpu-used", "3", "-c:a", "libopus", "-b:a", "128k"}},
+	".mp4": {"h264", []string{"-c:v", "libx264", "-crf", "18", "-preset", "fast",
+		"-c:a", "aac", "-b:a", "160k", "-movflags", "+faststart"}},
+}
+
+// mkv and anything else that can hold h264 and aac
+var defaultClipEncoder = clipEncoder{"h264", []string{"-c:v", "libx264", "-crf", "18", "-preset", "fast",
+	"-c:a", "aac", "-b:a", "160k"}}
+
+func clipEncoderFor(dst string) clipEncoder {
+	if enc, ok := clipEncoders[strings.ToLower(filepath.Ext(dst))]; ok {
+		return enc
+	}
+	return defaultClipEncoder
+}
+
+// ClipCodec is the video codec of a re-encoded clip written to dst
+func ClipCodec(dst string) string {
+	return clipEncoderFor(dst).codec
+}
+
+func clipArgs(src, dst string, from, to float64, reencode bool) []string {
+	if reencode {
+		args := []string{"-ss", fmt.Sprintf("%f", from), "-i", src,
+			"-t", fmt.Sprintf("%f", to-from)}
+		return append(append(args, clipEncoderFor(dst).args...), dst)
+	}
+	return []string{"-i", src,
 		"-ss", fmt.Sprintf("%f", from),
 		"-to", fmt.Sprintf("%f", to),
-		"-c", "copy",
-		dst)
+		"-c", "copy", dst}
+}
+
+// Clip cuts [from, to] seconds out of src into dst.
+// Copying the streams is fast but a video cut lands on keyframes, so re-encoding
+// gives a frame-accurate clip. Audio-only clips should always be copied, their
+// cuts are already within a few milliseconds
+func Clip(src, dst string, from, to float64, reencode bool) error {
+	_, _, err := Ffmpeg(clipArgs(src, dst, from, to, reencode)...)
 	return err
 }
 
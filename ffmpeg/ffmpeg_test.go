@@ -0,0 +1,41 @@
+package ffmpeg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClipArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		dst      string
+		reencode bool
+		want     string
+	}{
+		{"copy", "out.mp4", false,
+			"-i in -ss 1.500000 -to 4.000000 -c copy out.mp4"},
+		{"copy audio", "out.opus", false,
+			"-i in -ss 1.500000 -to 4.000000 -c copy out.opus"},
+		{"mp4", "out.mp4", true,
+			"-ss 1.500000 -i in -t 2.500000 -c:v libx264 -crf 18 -preset fast -c:a aac -b:a 160k -movflags +faststart out.mp4"},
+		{"webm", "out.WEBM", true,
+			"-ss 1.500000 -i in -t 2.500000 -c:v libvpx-vp9 -crf 31 -b:v 0 -row-mt 1 -deadline good -cpu-used 3 -c:a libopus -b:a 128k out.WEBM"},
+		{"mkv", "out.mkv", true,
+			"-ss 1.500000 -i in -t 2.500000 -c:v libx264 -crf 18 -preset fast -c:a aac -b:a 160k out.mkv"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := strings.Join(clipArgs("in", tc.dst, 1.5, 4, tc.reencode), " "); got != tc.want {
+				t.Errorf("got  %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestClipCodec(t *testing.T) {
+	for dst, want := range map[string]string{"a.webm": "vp9", "a.mp4": "h264", "a.mkv": "h264", "a": "h264"} {
+		if got := ClipCodec(dst); got != want {
+			t.Errorf("ClipCodec(%q) = %s, want %s", dst, got, want)
+		}
+	}
+}
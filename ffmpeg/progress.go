@@ -0,0 +1,72 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+type Progress struct {
+	OutTime float64 // seconds of output produced so far
+	Speed   float64 // multiple of realtime, 0 if unknown
+	Done    bool    // ffmpeg reported the end of processing
+}
+
+// parse the key=value blocks produced by `-progress`, calling `progress` at the end of each block
+func parseProgress(r io.Reader, progress func(Progress)) {
+	var p Progress
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "out_time_us", "out_time_ms": // both are actually microseconds
+			if us, err := strconv.ParseFloat(value, 64); err == nil {
+				p.OutTime = us / 1e6
+			}
+		case "speed":
+			if speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64); err == nil {
+				p.Speed = speed
+			}
+		case "progress":
+			p.Done = value == "end"
+			progress(p)
+		}
+	}
+}
+
+// runs ffmpeg with the provided args, calling `progress` as ffmpeg reports it,
+// and returns (stderr, error). stdout is consumed by the progress reports.
+// ffmpeg is killed if ctx is cancelled
+func FfmpegProgress(ctx context.Context, progress func(Progress), args ...string) ([]byte, error) {
+	ffmpeg := "ffmpeg"
+	args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	log.Infoln(ffmpeg, strings.Join(args, " "))
+	cmd := exec.CommandContext(ctx, ffmpeg, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		log.Errorf("ffmpeg error: %v", err)
+		return nil, err
+	}
+	parseProgress(stdout, progress)
+	err = cmd.Wait()
+
+	if err != nil {
+		log.Errorf("ffmpeg error: %v", err)
+	}
+	log.Infoln("stderr:", stderr.String())
+	return stderr.Bytes(), err
+}
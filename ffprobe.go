@@ -3,15 +3,74 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"ytdlp-site/config"
 	"ytdlp-site/ffmpeg"
+	"ytdlp-site/media"
 )
 
+type FFProbeStream struct {
+	CodecName string `json:"codec_name"`
+	CodecType string `json:"codec_type"`
+	Profile   string `json:"profile"`
+	Level     int    `json:"level"`
+	PixFmt    string `json:"pix_fmt"`
+}
+
 type FFProbeOutput struct {
-	Streams []struct {
-		CodecName string `json:"codec_name"`
-	} `json:"streams"`
+	Streams []FFProbeStream `json:"streams"`
+}
+
+// the first video and first audio stream of a file
+func getStreams(filename string) (video, audio *FFProbeStream, err error) {
+	output, _, err := ffmpeg.Ffprobe("-v", "quiet", "-print_format", "json", "-show_streams", filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	var ffprobeOutput FFProbeOutput
+	if err := json.Unmarshal(output, &ffprobeOutput); err != nil {
+		return nil, nil, err
+	}
+	for i, stream := range ffprobeOutput.Streams {
+		if stream.CodecType == "video" && video == nil && stream.CodecName != "mjpeg" && stream.CodecName != "png" {
+			video = &ffprobeOutput.Streams[i] // skip cover art
+		} else if stream.CodecType == "audio" && audio == nil {
+			audio = &ffprobeOutput.Streams[i]
+		}
+	}
+	return video, audio, nil
+}
+
+// codecs of the first video and first audio stream of a file
+func getCodecs(filename string) (video, audio string, err error) {
+	videoStream, audioStream, err := getStreams(filename)
+	if err != nil {
+		return "", "", err
+	}
+	if videoStream != nil {
+		video = videoStream.CodecName
+	}
+	if audioStream != nil {
+		audio = audioStream.CodecName
+	}
+	return video, audio, nil
+}
+
+// record the MIME type and codec of a media file
+func setMediaInfo(f *media.MediaFile, path string, isVideo bool) {
+	f.Type = media.TypeFor(path, isVideo)
+	videoCodec, audioCodec, err := getCodecs(path)
+	if err != nil {
+		log.Warnln("couldn't probe codecs of", path, err)
+		return
+	}
+	if isVideo {
+		f.Codec = videoCodec
+	} else {
+		f.Codec = audioCodec
+	}
 }
 
 func getAudioFormat(filename string) (string, error) {
@@ -81,3 +140,20 @@ func getFormatBitrate(path string) (uint, error) {
 	}
 	return uint(bitrate), nil
 }
+
+// record the type and codec of media files created before they were tracked
+func backfillMediaInfo() {
+	var videos []media.Video
+	db.Where("type = ? OR type IS NULL", "").Find(&videos)
+	for _, video := range videos {
+		setMediaInfo(&video.MediaFile, filepath.Join(config.GetDataDir(), video.Filename), true)
+		db.Model(&video).Updates(map[string]interface{}{"type": video.Type, "codec": video.Codec})
+	}
+
+	var audios []media.Audio
+	db.Where("type = ? OR type IS NULL", "").Find(&audios)
+	for _, audio := range audios {
+		setMediaInfo(&audio.MediaFile, filepath.Join(config.GetDataDir(), audio.Filename), false)
+		db.Model(&audio).Updates(map[string]interface{}{"type": audio.Type, "codec": audio.Codec})
+	}
+}
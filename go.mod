@@ -10,8 +10,10 @@ require (
 	github.com/labstack/echo/v4 v4.10.2
 	github.com/sirupsen/logrus v1.9.3
 	golang.org/x/crypto v0.9.0
+	golang.org/x/sys v0.8.0
 	gorm.io/driver/sqlite v1.5.1
 	gorm.io/gorm v1.25.1
+	rsc.io/qr v0.2.0
 )
 
 require (
@@ -26,7 +28,6 @@ require (
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
 	golang.org/x/net v0.10.0 // indirect
-	golang.org/x/sys v0.8.0 // indirect
 	golang.org/x/text v0.9.0 // indirect
 	golang.org/x/time v0.3.0 // indirect
 )
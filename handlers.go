@@ -1,11 +1,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -15,12 +16,17 @@ import (
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 
+	"ytdlp-site/authz"
 	"ytdlp-site/config"
 	"ytdlp-site/ffmpeg"
 	"ytdlp-site/handlers"
+	"ytdlp-site/jobs"
 	"ytdlp-site/media"
 	"ytdlp-site/originals"
 	"ytdlp-site/playlists"
+	"ytdlp-site/profiles"
+	"ytdlp-site/quota"
+	"ytdlp-site/search"
 	"ytdlp-site/transcodes"
 	"ytdlp-site/users"
 	"ytdlp-site/ytdlp"
@@ -29,25 +35,29 @@ import (
 var ytdlpAudioOptions = []string{"-f", "bestvideo[height<=1080]+bestaudio/best[height<=1080]"}
 var ytdlpVideoOptions = []string{"-f", "bestaudio"}
 
-type DisplayVideoClip struct {
+type DisplayClip struct {
 	TempURL
-	ID    uint // VideoClip.ID
-	Start string
-	Stop  string
+	ID       uint // VideoClip.ID or AudioClip.ID
+	Title    string
+	Filename string
+	Start    string
+	Stop     string
 }
 
 func registerHandler(c echo.Context) error {
-	return c.Render(http.StatusOK, "register.html", nil)
+	return c.Render(http.StatusOK, "register.html", map[string]interface{}{
+		"invite": c.QueryParam("invite"),
+	})
 }
 
 func registerPostHandler(c echo.Context) error {
+	invite := c.FormValue("invite")
 	username := c.FormValue("username")
 	password := c.FormValue("password")
 
-	err := users.Create(db, username, password)
-
+	_, err := users.RedeemInvite(db, invite, username, password)
 	if err != nil {
-		return c.String(http.StatusInternalServerError, "Error creating user")
+		return c.String(http.StatusBadRequest, "Error creating user: "+err.Error())
 	}
 
 	return c.Redirect(http.StatusSeeOther, "/login")
@@ -64,20 +74,81 @@ func homeHandler(c echo.Context) error {
 }
 
 func downloadHandler(c echo.Context) error {
+	profileList, err := profiles.List(db)
+	if err != nil {
+		log.Errorln("couldn't retrieve profiles", err)
+	}
 	return c.Render(http.StatusOK, "download.html",
 		map[string]interface{}{
-			"Footer": handlers.MakeFooter(),
+			"profiles": profileList,
+			"Footer":   handlers.MakeFooter(),
 		})
 }
 
+// parse a requested profile ID, 0 if none was chosen
+func parseProfileID(s string) (uint, error) {
+	if s == "" {
+		return 0, nil
+	}
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid profile id")
+	}
+	if _, err := profiles.Get(db, uint(id)); err != nil {
+		return 0, fmt.Errorf("no such profile")
+	}
+	return uint(id), nil
+}
+
 func isPlaylistUrl(url string) bool {
 	return strings.Contains(strings.ToLower(url), "playlist")
 }
 
+// create a playlist and start retrieving its entries
+func createPlaylist(userID uint, url string, audioOnly, subscribe bool, profileID uint, subLangs string) (playlists.Playlist, error) {
+	playlist := playlists.Playlist{
+		URL:          url,
+		UserID:       userID,
+		Audio:        audioOnly,
+		Video:        !audioOnly,
+		ProfileID:    profileID,
+		SubLangs:     subLangs,
+		Status:       playlists.StatusNotStarted,
+		Subscription: subscribe,
+	}
+	if subscribe {
+		playlist.RefreshHours = defaultSubscriptionRefreshHours
+		playlist.KeepLast = defaultSubscriptionKeepLast
+	}
+	if err := db.Create(&playlist).Error; err != nil {
+		return playlist, err
+	}
+	go refreshPlaylist(playlist.ID)
+	return playlist, nil
+}
+
+// create an original and queue it for download
+func createOriginal(userID uint, url string, audioOnly bool, profileID uint, subLangs string) (originals.Original, error) {
+	original := originals.Original{
+		URL:       url,
+		UserID:    userID,
+		Status:    originals.StatusNotStarted,
+		Audio:     audioOnly,
+		Video:     !audioOnly,
+		ProfileID: profileID,
+		SubLangs:  subLangs,
+	}
+	if err := db.Create(&original).Error; err != nil {
+		return original, err
+	}
+	return original, newDownload(original.ID)
+}
+
 func downloadPostHandler(c echo.Context) error {
 	url := c.FormValue("url")
 	userID := c.Get("user_id").(uint)
 	vaStr := c.FormValue("color")
+	subscribe := c.FormValue("subscribe") == "on"
 
 	audioOnly := false
 	if vaStr == "audio" {
@@ -87,56 +158,38 @@ func downloadPostHandler(c echo.Context) error {
 	} else {
 		return c.Redirect(http.StatusSeeOther, "/download")
 	}
+	profileID, err := parseProfileID(c.FormValue("profile_id"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	subLangs, err := parseSubLangs(c.FormValue("sub_langs"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
 
-	if isPlaylistUrl(url) {
-		playlist := playlists.Playlist{
-			URL:    url,
-			UserID: userID,
-			Audio:  audioOnly,
-			Video:  !audioOnly,
-			Status: playlists.StatusNotStarted,
+	if subscribe || isPlaylistUrl(url) {
+		if _, err := createPlaylist(userID, url, audioOnly, subscribe, profileID, subLangs); err != nil {
+			log.Errorln("couldn't create playlist", url, err)
 		}
-		db.Create(&playlist)
-		go startPlaylist(playlist.ID, url, audioOnly)
-
 	} else {
-		original := originals.Original{
-			URL:    url,
-			UserID: userID,
-			Status: originals.StatusNotStarted,
-			Audio:  audioOnly,
-			Video:  !audioOnly,
+		if _, err := createOriginal(userID, url, audioOnly, profileID, subLangs); err != nil {
+			log.Errorln("couldn't create original", url, err)
 		}
-		db.Create(&original)
-		go startDownload(original.ID, url, audioOnly)
 	}
 
 	return c.Redirect(http.StatusSeeOther, "/videos")
 }
 
 type Meta struct {
-	title  string
-	artist string
-}
-
-func getYtdlpTitle(url string, args []string) (string, error) {
-	args = append(args, "--simulate", "--print", "%(title)s", url)
-
-	cmd, cancel, err := ytdlp.Start(args...)
-	defer cancel()
-	if err != nil {
-		log.Errorln(err)
-		return "", err
-	}
-	stdout, _, err := cmd.Wait()
-	if err != nil {
-		log.Errorln(err)
-		return "", err
-	}
-	return strings.TrimSpace(string(stdout)), nil
+	title       string
+	artist      string
+	description string
+	chapters    []originals.Chapter
+	subtitles   map[string]bool // languages with uploaded, not automatic, subtitles
 }
 
 type PlaylistEntry struct {
+	Type  string `json:"_type"`
 	URL   string `json:"url"`
 	Title string `json:"title"`
 }
@@ -162,16 +215,6 @@ func getYtdlpPlaylist(url string) (PlaylistData, error) {
 	return data, nil
 }
 
-func getYtdlpArtist(url string, args []string) (string, error) {
-	args = append(args, "--simulate", "--print", "%(uploader)s", url)
-	stdout, _, err := ytdlp.Run(args...)
-	if err != nil {
-		log.Errorln(err)
-		return "", err
-	}
-	return strings.TrimSpace(string(stdout)), nil
-}
-
 func getYtdlpExt(url string, args []string) (string, error) {
 	args = append(args, "--simulate", "--print", "%(ext)s", url)
 	stdout, _, err := ytdlp.Run(args...)
@@ -182,20 +225,52 @@ func getYtdlpExt(url string, args []string) (string, error) {
 	return strings.TrimSpace(string(stdout)), nil
 }
 
-func getYtdlpMeta(url string, args []string) (Meta, error) {
+type ytdlpChapter struct {
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+	Title     string  `json:"title"`
+}
 
-	meta := Meta{}
-	var err error
+// the parts of yt-dlp's info JSON the site keeps
+type ytdlpInfo struct {
+	Title       string                     `json:"title"`
+	Uploader    string                     `json:"uploader"`
+	Description string                     `json:"description"`
+	Chapters    []ytdlpChapter             `json:"chapters"` // null when the site has none
+	Subtitles   map[string]json.RawMessage `json:"subtitles"`
+}
 
-	meta.title, err = getYtdlpTitle(url, args)
+// retrieve all of a video's metadata with one extraction
+func getYtdlpMeta(url string, args []string) (Meta, error) {
+	meta := Meta{}
+	args = append(args, "--dump-single-json", url)
+	stdout, _, err := ytdlp.Run(args...)
 	if err != nil {
+		log.Errorln(err)
 		return meta, err
 	}
-	meta.artist, err = getYtdlpArtist(url, args)
-	if err != nil {
+
+	var info ytdlpInfo
+	if err := json.Unmarshal(stdout, &info); err != nil {
 		return meta, err
 	}
-
+	meta.title = info.Title
+	meta.artist = info.Uploader
+	meta.description = info.Description
+	for _, ch := range info.Chapters {
+		if ch.EndTime <= ch.StartTime {
+			continue
+		}
+		meta.chapters = append(meta.chapters, originals.Chapter{
+			Title:   ch.Title,
+			StartMS: uint(ch.StartTime*1000 + 0.5),
+			StopMS:  uint(ch.EndTime*1000 + 0.5),
+		})
+	}
+	meta.subtitles = map[string]bool{}
+	for lang := range info.Subtitles {
+		meta.subtitles[lang] = true
+	}
 	return meta, nil
 }
 
@@ -309,23 +384,6 @@ func getSize(path string) (int64, error) {
 	return fi.Size(), nil
 }
 
-func humanSize(bytes int64) string {
-	const (
-		KiB = 1024
-		MiB = 1024 * KiB
-		GiB = 1024 * MiB
-	)
-
-	if bytes >= GiB {
-		return fmt.Sprintf("%.1f GiB", float64(bytes)/float64(GiB))
-	} else if bytes >= MiB {
-		return fmt.Sprintf("%.1f MiB", float64(bytes)/float64(MiB))
-	} else if bytes >= KiB {
-		return fmt.Sprintf("%.1f KiB", float64(bytes)/float64(KiB))
-	}
-	return fmt.Sprintf("%d bytes", bytes)
-}
-
 type VideoMeta struct {
 	width  uint
 	height uint
@@ -389,8 +447,8 @@ func getAudioBitrate(path string) (uint, error) {
 	if err != nil {
 		return 0, err
 	}
-	if codec == "opus" {
-		return getFormatBitrate(path)
+	if codec == "opus" || codec == "flac" || codec == "vorbis" {
+		return getFormatBitrate(path) // no per-stream bitrate in these containers
 	} else {
 		return getStreamBitrate(path, 0)
 	}
@@ -416,67 +474,148 @@ func getAudioMeta(path string) (AudioMeta, error) {
 	}, nil
 }
 
-func newAudioTranscode(mediaId, originalId, kbps uint, srcKind string) {
-	t := transcodes.Transcode{
-		SrcID:      mediaId,
-		OriginalID: originalId,
-		SrcKind:    srcKind,
-		DstKind:    "audio",
-		Kbps:       kbps,
-		TimeSubmit: time.Now(),
-		Status:     "pending",
+// whether the owner of an original may create more media
+func checkTranscodeQuota(originalId uint) error {
+	var orig originals.Original
+	if err := db.First(&orig, originalId).Error; err != nil {
+		return err
 	}
-	db.Create(&t)
+	return quota.CheckTranscode(orig.UserID)
+}
 
-	if srcKind == "video" {
+// queue a transcode and start a worker for it
+func queueTranscode(t transcodes.Transcode) (transcodes.Transcode, error) {
+	if err := checkTranscodeQuota(t.OriginalID); err != nil {
+		return transcodes.Transcode{}, err
+	}
+	if t.DstKind == "video" {
+		// renditions that may become HLS variants need aligned segments
+		if profile, err := originalProfile(t.OriginalID); err == nil && profile.HLS {
+			t.KeyframeSeconds = hlsSegmentSeconds
+		}
+	}
+	t.TimeSubmit = time.Now()
+	t.Status = "pending"
+	t.ApplyDefaults()
+	if err := db.Create(&t).Error; err != nil {
+		return t, err
+	}
+
+	if t.SrcKind == "video" {
 		var srcVideo media.Video
 		err := db.First(&srcVideo, "id = ?", t.SrcID).Error
 		if err != nil {
 			fmt.Println("no such source video for video Transcode", t)
 			db.Delete(&t)
-			return
+			return t, err
 		}
 		srcFilepath := filepath.Join(config.GetDataDir(), srcVideo.Filename)
-		go videoToAudio(sem, t.ID, srcFilepath)
-	} else if srcKind == "audio" {
+		if t.DstKind == "video" {
+			go videoToVideo(sem, t.ID, srcFilepath)
+		} else {
+			go videoToAudio(sem, t.ID, srcFilepath)
+		}
+	} else if t.SrcKind == "audio" && t.DstKind == "audio" {
 		var srcAudio media.Audio
 		err := db.First(&srcAudio, "id = ?", t.SrcID).Error
 		if err != nil {
 			log.Errorln("no such source audio for audio Transcode", t)
 			db.Delete(&t)
-			return
+			return t, err
 		}
 		srcFilepath := filepath.Join(config.GetDataDir(), srcAudio.Filename)
 		go audioToAudio(sem, t.ID, srcFilepath)
 	} else {
 		fmt.Println("unexpected src/dst kinds for Transcode", t)
 		db.Delete(&t)
+		return t, fmt.Errorf("unexpected source kind %q", t.SrcKind)
 	}
+	return t, nil
 }
 
-func newVideoTranscode(videoId, originalId, targetHeight uint, targetFPS float64) {
-	t := transcodes.Transcode{
-		SrcID:      videoId,
-		OriginalID: originalId,
-		SrcKind:    "video",
-		DstKind:    "video",
-		Height:     targetHeight,
-		FPS:        targetFPS,
-		TimeSubmit: time.Now(),
-		Status:     "pending",
+// the profile an original is transcoded with
+func originalProfile(originalId uint) (profiles.Profile, error) {
+	var orig originals.Original
+	if err := db.First(&orig, originalId).Error; err != nil {
+		return profiles.Profile{}, err
 	}
-	db.Create(&t)
+	var user users.User
+	db.First(&user, orig.UserID)
+	return profiles.Resolve(db, orig.ProfileID, user.ProfileID)
+}
 
-	var srcVideo media.Video
-	err := db.First(&srcVideo, "id = ?", t.SrcID).Error
+// the first rendition of a kind in an original's profile, whose encoder
+// settings are reused for transcodes requested by hand
+func originalRendition(originalId uint, kind string) profiles.Rendition {
+	profile, err := originalProfile(originalId)
 	if err != nil {
-		fmt.Println("no such source video for video Transcode", t)
-		db.Delete(&t)
-		return
+		log.Errorln("couldn't resolve profile for original", originalId, err)
+	}
+	for _, r := range profile.Renditions {
+		if r.Kind == kind {
+			return r
+		}
+	}
+	return profiles.Rendition{Kind: kind}
+}
+
+// a transcode to the rendition's codecs and container
+func renditionTranscode(r profiles.Rendition) transcodes.Transcode {
+	return transcodes.Transcode{
+		DstKind:    r.Kind,
+		VideoCodec: r.VideoCodec,
+		CRF:        r.CRF,
+		VideoKbps:  r.VideoKbps,
+		Preset:     r.Preset,
+		Kbps:       r.AudioKbps,
+		AudioCodec: r.AudioCodec,
+		Container:  r.Container,
 	}
-	srcFilepath := filepath.Join(config.GetDataDir(), srcVideo.Filename)
+}
 
-	go videoToVideo(sem, t.ID, srcFilepath)
+func newAudioTranscode(mediaId, originalId, kbps uint, srcKind string) (transcodes.Transcode, error) {
+	t := renditionTranscode(originalRendition(originalId, "audio"))
+	t.SrcID = mediaId
+	t.OriginalID = originalId
+	t.SrcKind = srcKind
+	t.Kbps = kbps
+	return queueTranscode(t)
+}
+
+func newVideoTranscode(videoId, originalId, targetHeight uint, targetFPS float64) (transcodes.Transcode, error) {
+	t := renditionTranscode(originalRendition(originalId, "video"))
+	t.SrcID = videoId
+	t.OriginalID = originalId
+	t.SrcKind = "video"
+	t.Height = targetHeight
+	t.FPS = targetFPS
+	t.Kbps = 0 // chosen from the height
+	return queueTranscode(t)
+}
+
+// queue a video transcode of an original's video
+func transcodeOriginalToVideo(originalId, height uint, fps float64) (transcodes.Transcode, error) {
+	var video media.Video
+	err := db.Where("source = ?", "original").Where("original_id = ?", originalId).First(&video).Error
+	if err != nil {
+		return transcodes.Transcode{}, fmt.Errorf("no video record for original %d: %w", originalId, err)
+	}
+	return newVideoTranscode(video.ID, originalId, height, fps)
+}
+
+// queue an audio transcode of an original's video, or its audio if there is no video
+func transcodeOriginalToAudio(originalId, kbps uint) (transcodes.Transcode, error) {
+	var video media.Video
+	var audio media.Audio
+	err := db.Where("source = ?", "original").Where("original_id = ?", originalId).First(&video).Error
+	if err == nil {
+		return newAudioTranscode(video.ID, originalId, kbps, "video")
+	}
+	err = db.Where("source = ?", "original").Where("original_id = ?", originalId).First(&audio).Error
+	if err == nil {
+		return newAudioTranscode(audio.ID, originalId, kbps, "audio")
+	}
+	return transcodes.Transcode{}, fmt.Errorf("no audio or video record for original %d: %w", originalId, err)
 }
 
 func processOriginal(originalID uint) {
@@ -495,6 +634,29 @@ func processOriginal(originalID uint) {
 		hasOriginalAudio = false
 	}
 
+	profile, err := originalProfile(originalID)
+	if err != nil {
+		log.Errorln("couldn't resolve profile for original", originalID, err)
+		// rather than staying "download completed" with nothing queued
+		originals.SetStatus(originalID, originals.StatusFailed)
+		return
+	}
+	log.Debugf("transcoding original %d with profile %q", originalID, profile.Name)
+
+	// queue t, or report that the rest shouldn't be queued either
+	queue := func(t transcodes.Transcode) bool {
+		_, err := queueTranscode(t)
+		if errors.Is(err, quota.ErrExceeded) {
+			log.Infoln("not transcoding original", originalID, err)
+			originals.SetStatus(originalID, originals.StatusQuotaExceeded)
+			return false
+		}
+		if err != nil {
+			log.Errorln("couldn't queue transcode for original", originalID, err)
+		}
+		return true
+	}
+
 	if hasOriginalVideo {
 
 		videoFilepath := filepath.Join(config.GetDataDir(), video.Filename)
@@ -504,16 +666,32 @@ func processOriginal(originalID uint) {
 			return
 		}
 
-		// create audio transcodes
-		for _, kbps := range []uint{64 /*, 96, 128, 160, 192*/} {
-			newAudioTranscode(video.ID, originalID, kbps, "video")
-		}
-
-		// create video transcodes
-		for _, targetHeight := range []uint{540, 480, 360, 240, 144} {
-			if targetHeight <= video.Height {
-				newVideoTranscode(video.ID, originalID, targetHeight, video.FPS)
-				break
+		seen := map[string]bool{} // height, codec & container
+		for _, r := range profile.Renditions {
+			t := renditionTranscode(r)
+			t.SrcID = video.ID
+			t.OriginalID = originalID
+			t.SrcKind = "video"
+
+			if r.Kind == "video" {
+				// never upscale, and skip renditions that end up the same size
+				t.Height = r.Height
+				if t.Height == 0 || (video.Height > 0 && t.Height > video.Height) {
+					t.Height = video.Height
+				}
+				key := fmt.Sprint(t.Height, t.VideoCodec, t.Container)
+				if t.Height == 0 || seen[key] {
+					continue
+				}
+				seen[key] = true
+
+				t.FPS = video.FPS
+				if r.FPS > 0 && (video.FPS == 0 || r.FPS < video.FPS) {
+					t.FPS = r.FPS
+				}
+			}
+			if !queue(t) {
+				return
 			}
 		}
 
@@ -526,9 +704,17 @@ func processOriginal(originalID uint) {
 			return
 		}
 
-		// create audio transcodes
-		for _, kbps := range []uint{64 /*, 96, 128, 160, 192*/} {
-			newAudioTranscode(audio.ID, originalID, kbps, "audio")
+		for _, r := range profile.Renditions {
+			if r.Kind != "audio" {
+				continue
+			}
+			t := renditionTranscode(r)
+			t.SrcID = audio.ID
+			t.OriginalID = originalID
+			t.SrcKind = "audio"
+			if !queue(t) {
+				return
+			}
 		}
 
 	} else {
@@ -537,7 +723,30 @@ func processOriginal(originalID uint) {
 
 }
 
-func startDownload(originalID uint, videoURL string, audioOnly bool) {
+// returns a function that forwards yt-dlp progress for an original to listeners, at most once per second
+func downloadProgressFunc(originalID uint) func(ytdlp.Progress) {
+	var last time.Time // StartProgress serializes the calls
+	return func(p ytdlp.Progress) {
+		if time.Since(last) < time.Second {
+			return
+		}
+		last = time.Now()
+
+		percent := -1.0
+		if p.Total > 0 {
+			percent = 100 * float64(p.Downloaded) / float64(p.Total)
+		}
+		originals.SetDownloadProgress(originalID, originals.Progress{
+			Bytes:   p.Downloaded,
+			Total:   p.Total,
+			Speed:   p.Speed,
+			ETA:     p.ETA,
+			Percent: percent,
+		})
+	}
+}
+
+func startDownload(originalID uint, videoURL string, audioOnly bool) error {
 	log.Debugf("startDownload audioOnly=%t", audioOnly)
 
 	// metadata phase
@@ -552,17 +761,24 @@ func startDownload(originalID uint, videoURL string, audioOnly bool) {
 	if err != nil {
 		log.Errorln("couldn't retrieve metadata:", err)
 		originals.SetStatus(originalID, originals.StatusFailed)
-		return
+		return err
 	}
 	log.Debugf("original metadata %v", origMeta)
 	err = db.Model(&originals.Original{}).Where("id = ?", originalID).Updates(map[string]interface{}{
-		"title":  origMeta.title,
-		"artist": origMeta.artist,
+		"title":       origMeta.title,
+		"artist":      origMeta.artist,
+		"description": origMeta.description,
 	}).Error
 	if err != nil {
 		log.Errorln("couldn't store metadata:", err)
 		originals.SetStatus(originalID, originals.StatusFailed)
-		return
+		return err
+	}
+	if err := originals.SetChapters(originalID, origMeta.chapters); err != nil {
+		log.Errorln("couldn't store chapters:", err)
+	}
+	if err := search.Index(originalID); err != nil {
+		log.Errorln("couldn't index original", originalID, err)
 	}
 
 	// download original
@@ -574,28 +790,37 @@ func startDownload(originalID uint, videoURL string, audioOnly bool) {
 	if err != nil {
 		log.Errorln("Error creating temporary directory:", err)
 		originals.SetStatus(originalID, originals.StatusFailed)
-		return
+		return err
 	}
 	defer os.RemoveAll(tempDir)
+	trackTempDir(tempDir)
+	defer untrackTempDir(tempDir)
 	log.Debugln("created", tempDir)
 
-	// download into temporary directory
+	// download into temporary directory, with any requested subtitles
+	var orig originals.Original
+	if err := db.First(&orig, originalID).Error; err != nil {
+		log.Errorln("couldn't find original", originalID, err)
+		originals.SetStatus(originalID, originals.StatusFailed)
+		return err
+	}
 	var args []string
 	if audioOnly {
 		args = ytdlpVideoOptions
 	} else {
 		args = ytdlpAudioOptions
 	}
-	ytdlp := "yt-dlp"
-	ytdlpArgs := append(args, videoURL)
-	fmt.Println(ytdlp, strings.Join(ytdlpArgs, " "))
-	cmd := exec.Command(ytdlp, ytdlpArgs...)
-	cmd.Dir = tempDir
-	err = cmd.Run()
+	ytdlpArgs := append(append(args, subtitleArgs(orig.SubLangs)...), "-P", tempDir, videoURL)
+	cmd, cancel, err := ytdlp.StartProgress(downloadProgressFunc(originalID), ytdlpArgs...)
+	defer cancel()
+	if err == nil {
+		jobs.Register(jobs.Download, originalID, cancel)
+		_, _, err = cmd.Wait()
+	}
 	if err != nil {
 		log.Errorln("yt-dlp failed")
 		originals.SetStatus(originalID, originals.StatusFailed)
-		return
+		return err
 	}
 
 	// discover name of downloaded file
@@ -603,7 +828,7 @@ func startDownload(originalID uint, videoURL string, audioOnly bool) {
 	if err != nil {
 		log.Errorln("Error reading directory:", err)
 		originals.SetStatus(originalID, originals.StatusFailed)
-		return
+		return err
 	}
 	dlFilename := ""
 	for _, dirEnt := range dirEnts {
@@ -616,6 +841,7 @@ func startDownload(originalID uint, videoURL string, audioOnly bool) {
 	if dlFilename == "" {
 		log.Errorln("couldn't find a downloaded file")
 		originals.SetStatus(originalID, originals.StatusFailed)
+		return fmt.Errorf("no file downloaded from %s", videoURL)
 	}
 
 	// move to data directory
@@ -626,7 +852,7 @@ func startDownload(originalID uint, videoURL string, audioOnly bool) {
 	if err != nil {
 		log.Errorln("rename downloaded media error", srcPath, "->", dlFilepath, ":", err)
 		originals.SetStatus(originalID, originals.StatusFailed)
-		return
+		return err
 	}
 
 	if audioOnly {
@@ -634,7 +860,7 @@ func startDownload(originalID uint, videoURL string, audioOnly bool) {
 		if err != nil {
 			log.Errorln("couldn't get audio file metadata", err)
 			originals.SetStatus(originalID, originals.StatusFailed)
-			return
+			return err
 		}
 
 		audio := media.Audio{
@@ -646,18 +872,19 @@ func startDownload(originalID uint, videoURL string, audioOnly bool) {
 			OriginalID: originalID,
 			Source:     "original",
 		}
+		setMediaInfo(&audio.MediaFile, dlFilepath, false)
 		fmt.Println("create Audio", audio)
-		if db.Create(&audio).Error != nil {
+		if err := db.Create(&audio).Error; err != nil {
 			fmt.Println("Couldn't create audio entry", err)
 			originals.SetStatus(originalID, originals.StatusFailed)
-			return
+			return err
 		}
 	} else {
 		mediaMeta, err := getVideoMeta(dlFilepath)
 		if err != nil {
 			log.Errorln("couldn't get video file metadata", err)
 			originals.SetStatus(originalID, originals.StatusFailed)
-			return
+			return err
 		}
 
 		video := media.Video{
@@ -674,52 +901,29 @@ func startDownload(originalID uint, videoURL string, audioOnly bool) {
 			OriginalID: originalID,
 			Source:     "original",
 		}
+		setMediaInfo(&video.MediaFile, dlFilepath, true)
 		log.Debugln("create Video", video)
-		if db.Create(&video).Error != nil {
+		if err := db.Create(&video).Error; err != nil {
 			log.Errorln("Couldn't create video entry", err)
 			originals.SetStatus(originalID, originals.StatusFailed)
-			return
+			return err
 		}
 	}
 
-	originals.SetStatus(originalID, originals.StatusDownloadCompleted)
-	processOriginal(originalID)
-}
-
-func startPlaylist(id uint, url string, audioOnly bool) {
-	// retrieve playlist metadata
-	pl, err := getYtdlpPlaylist(url)
-	if err != nil {
-		playlists.SetStatus(id, playlists.StatusFailed)
-		return
+	if orig.SubLangs != "" {
+		saveSubtitles(originalID, filepath.Join(tempDir, subtitleDir), origMeta.subtitles)
 	}
-	err = db.Model(&playlists.Playlist{}).Where("id = ?", id).Updates(map[string]interface{}{
-		"title": pl.Title,
-	}).Error
-	if err != nil {
-		playlists.SetStatus(id, playlists.StatusFailed)
-		return
+	if err := search.Index(originalID); err != nil {
+		log.Errorln("couldn't index original", originalID, err)
 	}
 
-	for _, entry := range pl.Entries {
-		// TODO: check if an original with this URL and playlist ID already exists
-
-		original := originals.Original{
-			Title:      entry.Title,
-			URL:        entry.URL,
-			Status:     originals.StatusNotStarted,
-			Video:      !audioOnly,
-			Audio:      audioOnly,
-			Playlist:   true,
-			PlaylistID: id,
-		}
-		err = db.Create(&original).Error
-		if err != nil {
-			playlists.SetStatus(id, playlists.StatusFailed)
-			return
-		}
+	// past this point the download can't be cancelled, only its transcodes
+	if jobs.Finish(jobs.Download, originalID) {
+		return context.Canceled
 	}
-	playlists.SetStatus(id, playlists.StatusCompleted)
+	originals.SetStatus(originalID, originals.StatusDownloadCompleted)
+	processOriginal(originalID)
+	return nil
 }
 
 func videosHandler(c echo.Context) error {
@@ -753,6 +957,8 @@ type VideoTemplate struct {
 	Width            uint
 	Height           uint
 	FPS              string
+	Type             string
+	Codec            string
 	Size             string
 	Filename         string
 	DownloadFilename string
@@ -764,6 +970,8 @@ type AudioTemplate struct {
 	ID               uint // Audio.ID
 	Source           string
 	Kbps             string
+	Type             string
+	Codec            string
 	Size             string
 	Filename         string
 	DownloadFilename string
@@ -807,10 +1015,10 @@ func makeNiceFilename(input string) string {
 }
 
 func videoHandler(c echo.Context) error {
-	id, _ := strconv.Atoi(c.Param("id"))
-	var orig originals.Original
-	if err := db.First(&orig, id).Error; err != nil {
-		return c.Redirect(http.StatusSeeOther, "/videos")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	orig, err := authz.Original(c.Get("user_id").(uint), uint(id))
+	if err != nil {
+		return c.String(http.StatusNotFound, "no such original")
 	}
 
 	var videos []media.Video
@@ -825,14 +1033,34 @@ func videoHandler(c echo.Context) error {
 
 	var videoClips []media.VideoClip
 	db.Where("original_id = ?", id).
+		Order("start_ms ASC").
 		Find(&videoClips)
 
+	var audioClips []media.AudioClip
+	db.Where("original_id = ?", id).
+		Order("start_ms ASC").
+		Find(&audioClips)
+
+	chapters, _ := originals.GetChapters(uint(id))
+
+	var trans []transcodes.Transcode
+	db.Where("original_id = ?", id).
+		Order("id ASC").
+		Find(&trans)
+
+	var hls *media.HLS
+	var hlsPackage media.HLS
+	if err := db.Where("original_id = ?", id).Order("id DESC").First(&hlsPackage).Error; err == nil {
+		hls = &hlsPackage
+	}
+
 	dataDir := config.GetDataDir()
 
 	// create temporary URLs
 	var videoURLs []VideoTemplate
 	var audioURLs []AudioTemplate
-	var clipDisplays []DisplayVideoClip
+	var clipDisplays []DisplayClip
+	var audioClipDisplays []DisplayClip
 	for _, video := range videos {
 		tempURL, err := CreateTempURL(filepath.Join(dataDir, video.Filename))
 		if err != nil {
@@ -847,7 +1075,9 @@ func videoHandler(c echo.Context) error {
 			Width:            video.Width,
 			Height:           video.Height,
 			FPS:              fmt.Sprintf("%.1f", video.FPS),
-			Size:             humanSize(video.Size),
+			Type:             video.Type,
+			Codec:            video.Codec,
+			Size:             handlers.HumanSize(video.Size),
 			Filename:         video.Filename,
 			DownloadFilename: makeNiceFilename(orig.Title),
 			StreamRate:       fmt.Sprintf("%.1f KiB/s", rate/1024),
@@ -867,7 +1097,9 @@ func videoHandler(c echo.Context) error {
 			ID:               audio.ID,
 			Source:           audio.Source,
 			Kbps:             fmt.Sprintf("%.1f kbps", kbps),
-			Size:             humanSize(audio.Size),
+			Type:             audio.Type,
+			Codec:            audio.Codec,
+			Size:             handlers.HumanSize(audio.Size),
 			Filename:         audio.Filename,
 			DownloadFilename: makeNiceFilename(orig.Title),
 			StreamRate:       fmt.Sprintf("%.1f KiB/s", rate/1024),
@@ -881,37 +1113,104 @@ func videoHandler(c echo.Context) error {
 			continue
 		}
 
-		clipDisplays = append(clipDisplays, DisplayVideoClip{
-			TempURL: tempURL,
-			ID:      clip.ID,
-			Start:   fmt.Sprintf("%.2f", float64(1000*clip.StartMS)),
-			Stop:    fmt.Sprintf("%.2f", float64(1000*clip.StopMS)),
+		clipDisplays = append(clipDisplays, DisplayClip{
+			TempURL:  tempURL,
+			ID:       clip.ID,
+			Title:    clip.Title,
+			Filename: clip.Filename,
+			Start:    humanLength(float64(clip.StartMS) / 1000),
+			Stop:     humanLength(float64(clip.StopMS) / 1000),
+		})
+	}
+	for _, clip := range audioClips {
+		tempURL, err := CreateTempURL(filepath.Join(dataDir, clip.Filename))
+		if err != nil {
+			continue
+		}
+
+		audioClipDisplays = append(audioClipDisplays, DisplayClip{
+			TempURL:  tempURL,
+			ID:       clip.ID,
+			Title:    clip.Title,
+			Filename: clip.Filename,
+			Start:    humanLength(float64(clip.StartMS) / 1000),
+			Stop:     humanLength(float64(clip.StopMS) / 1000),
 		})
 	}
 
 	return c.Render(http.StatusOK, "video.html",
 		map[string]interface{}{
-			"original": orig,
-			"videos":   videoURLs,
-			"audios":   audioURLs,
-			"clips":    clipDisplays,
-			"dataDir":  dataDir,
-			"Footer":   handlers.MakeFooter(),
+			"original":   orig,
+			"videos":     videoURLs,
+			"audios":     audioURLs,
+			"clips":      clipDisplays,
+			"audioClips": audioClipDisplays,
+			"chapters":   displayChapters(chapters),
+			"subtitles":  subtitleTemplates(uint(id)),
+			"transcodes": trans,
+			"hls":        hls,
+			"canHLS":     len(hlsVariants(uint(id))) > 0,
+			"dataDir":    dataDir,
+			"Footer":     handlers.MakeFooter(),
 		})
 }
 
 func videoRestartHandler(c echo.Context) error {
-	id, _ := strconv.Atoi(c.Param("id"))
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
 
-	// FIXME: rewrite this as an update
-	var orig originals.Original
-	if err := db.First(&orig, id).Error; err != nil {
-		return c.Redirect(http.StatusSeeOther, "/videos")
+	orig, err := authz.Original(c.Get("user_id").(uint), uint(id))
+	if err != nil {
+		return c.String(http.StatusNotFound, "no such original")
 	}
-	orig.Status = originals.StatusNotStarted
-	db.Save(&orig)
 
-	go startDownload(uint(id), orig.URL, orig.Audio)
+	if err := newDownload(orig.ID); err != nil {
+		log.Errorln("couldn't queue download for original", orig.ID, err)
+	}
+
+	referrer := c.Request().Referer()
+	if referrer == "" {
+		referrer = "/videos"
+	}
+	return c.Redirect(http.StatusSeeOther, referrer)
+}
+
+func videoCancelHandler(c echo.Context) error {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+
+	orig, err := authz.Original(c.Get("user_id").(uint), uint(id))
+	if err != nil {
+		return c.String(http.StatusNotFound, "no such original")
+	}
+
+	if !cancelDownload(orig.ID) {
+		// not downloading, so cancel any transcodes instead
+		var trans []transcodes.Transcode
+		db.Where("original_id = ?", orig.ID).Find(&trans)
+		for _, t := range trans {
+			if err := cancelTranscode(t.ID); err != nil {
+				log.Errorln("couldn't cancel transcode", t.ID, err)
+			}
+		}
+	}
+
+	referrer := c.Request().Referer()
+	if referrer == "" {
+		referrer = "/videos"
+	}
+	return c.Redirect(http.StatusSeeOther, referrer)
+}
+
+func transcodeCancelHandler(c echo.Context) error {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+
+	trans, err := authz.Transcode(c.Get("user_id").(uint), uint(id))
+	if err != nil {
+		return c.String(http.StatusNotFound, "no such transcode")
+	}
+
+	if err := cancelTranscode(trans.ID); err != nil {
+		log.Errorln("couldn't cancel transcode", id, err)
+	}
 
 	referrer := c.Request().Referer()
 	if referrer == "" {
@@ -926,6 +1225,8 @@ func deleteTranscodes(originalID uint) {
 }
 
 func deleteTranscodedVideos(originalID uint) {
+	deleteHLS(originalID) // packaged from the transcoded videos
+
 	var videos []media.Video
 	db.Where("original_id = ?", originalID).Where("source = ?", "transcode").Find(&videos)
 	for _, video := range videos {
@@ -973,20 +1274,30 @@ func deleteOriginal(id uint) error {
 		return err
 	}
 
+	deleteDownloads(id)
 	deleteTranscodes(id)
 	deleteTranscodedVideos(id)
 	deleteOriginalVideos(id)
 	deleteAudiosWithSource(id, "original")
 	deleteAudiosWithSource(id, "transcode")
+	handlers.DeleteClips(id)
+	deleteSubtitles(id)
+	search.Remove(id)
+	db.Delete(&originals.Chapter{}, "original_id = ?", id)
 
 	db.Delete(&orig)
+	updatePlaylistStatus(orig)
 
 	return nil
 }
 
 func deleteOriginalHandler(c echo.Context) error {
 	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
-	deleteOriginal(uint(id))
+	orig, err := authz.Original(c.Get("user_id").(uint), uint(id))
+	if err != nil {
+		return c.String(http.StatusNotFound, "no such original")
+	}
+	deleteOriginal(orig.ID)
 	return c.Redirect(http.StatusSeeOther, "/videos")
 }
 
@@ -1011,6 +1322,9 @@ func deleteVideo(id int) error {
 		log.Errorln("error deleting video record", id, err)
 		return err
 	}
+	if video.Source == "transcode" {
+		deleteHLS(video.OriginalID) // would still play the deleted rendition
+	}
 
 	return nil
 }
@@ -1021,6 +1335,9 @@ func deleteVideoHandler(c echo.Context) error {
 	if referrer == "" {
 		referrer = "/"
 	}
+	if _, err := authz.Video(c.Get("user_id").(uint), uint(id)); err != nil {
+		return c.String(http.StatusNotFound, "no such video")
+	}
 	err := deleteVideo(id)
 	if err != nil {
 		log.Errorln("delete video error", id, err)
@@ -1035,16 +1352,14 @@ func deleteAudioHandler(c echo.Context) error {
 		referrer = "/"
 	}
 
-	var audio media.Audio
-	result := db.First(&audio, id)
-	if result.Error != nil {
-		log.Errorln("error retrieving audio", id, result.Error)
-		return c.Redirect(http.StatusSeeOther, referrer)
+	audio, err := authz.Audio(c.Get("user_id").(uint), uint(id))
+	if err != nil {
+		return c.String(http.StatusNotFound, "no such audio")
 	}
 
 	filePath := filepath.Join(config.GetDataDir(), audio.Filename)
 	log.Debugln("remove", filePath)
-	err := os.Remove(filePath)
+	err = os.Remove(filePath)
 	if err != nil {
 		log.Errorln("coudn't remove", filePath, err)
 	}
@@ -1064,12 +1379,14 @@ func transcodeToVideoHandler(c echo.Context) error {
 		referrer = "/"
 	}
 
-	var video media.Video
-	err := db.Where("source = ?", "original").Where("original_id = ?", originalId).First(&video).Error
-	if err == gorm.ErrRecordNotFound {
-		log.Errorf("no video record for original %d: %v", originalId, err)
-	} else {
-		newVideoTranscode(video.ID, uint(originalId), uint(height), fps)
+	if _, err := authz.Original(c.Get("user_id").(uint), uint(originalId)); err != nil {
+		return c.String(http.StatusNotFound, "no such original")
+	}
+
+	if _, err := transcodeOriginalToVideo(uint(originalId), uint(height), fps); errors.Is(err, quota.ErrExceeded) {
+		return c.String(http.StatusForbidden, err.Error())
+	} else if err != nil {
+		log.Errorln(err)
 	}
 
 	return c.Redirect(http.StatusSeeOther, referrer)
@@ -1083,26 +1400,14 @@ func transcodeToAudioHandler(c echo.Context) error {
 		referrer = "/"
 	}
 
-	// check if there is an original video
-	hasOriginalVideo := true
-	hasOriginalAudio := true
-	var video media.Video
-	var audio media.Audio
-	err := db.Where("source = ?", "original").Where("original_id = ?", originalId).First(&video).Error
-	if err == gorm.ErrRecordNotFound {
-		hasOriginalVideo = false
-	}
-	err = db.Where("source = ?", "original").Where("original_id = ?", originalId).First(&audio).Error
-	if err == gorm.ErrRecordNotFound {
-		hasOriginalAudio = false
+	if _, err := authz.Original(c.Get("user_id").(uint), uint(originalId)); err != nil {
+		return c.String(http.StatusNotFound, "no such original")
 	}
 
-	if hasOriginalVideo {
-		newAudioTranscode(video.ID, uint(originalId), uint(kbps), "video")
-	} else if hasOriginalAudio {
-		newAudioTranscode(audio.ID, uint(originalId), uint(kbps), "audio")
-	} else {
-		log.Errorln("no audio or video record for original", originalId)
+	if _, err := transcodeOriginalToAudio(uint(originalId), uint(kbps)); errors.Is(err, quota.ErrExceeded) {
+		return c.String(http.StatusForbidden, err.Error())
+	} else if err != nil {
+		log.Errorln(err)
 	}
 
 	return c.Redirect(http.StatusSeeOther, referrer)
@@ -1122,6 +1427,10 @@ func tempHandler(c echo.Context) error {
 func processHandler(c echo.Context) error {
 	id, _ := strconv.ParseUint(c.Param("id"), 10, 64) // FIXME: strconv.ParseUint?
 
+	if _, err := authz.Original(c.Get("user_id").(uint), uint(id)); err != nil {
+		return c.String(http.StatusNotFound, "no such original")
+	}
+
 	deleteTranscodes(uint(id))
 	deleteAudiosWithSource(uint(id), "transcode")
 	deleteTranscodedVideos(uint(id))
@@ -1137,12 +1446,11 @@ func processHandler(c echo.Context) error {
 }
 
 func playlistHandler(c echo.Context) error {
-	id := c.Param("id")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
 
-	var playlist playlists.Playlist
-	err := db.Where(id).First(&playlist).Error
+	playlist, err := authz.Playlist(c.Get("user_id").(uint), uint(id))
 	if err != nil {
-		return c.String(http.StatusInternalServerError, fmt.Sprintf("%v", err))
+		return c.String(http.StatusNotFound, "no such playlist")
 	}
 
 	var origs []originals.Original
@@ -1164,17 +1472,21 @@ func playlistHandler(c echo.Context) error {
 		return c.String(http.StatusInternalServerError, fmt.Sprintf("%v", err))
 	}
 
-	return c.Render(http.StatusOK, "playlist.html",
-		map[string]interface{}{
-			"playlist":  playlist,
-			"unwatched": origs,
-			"watched":   watchedOrigs,
-			"Footer":    handlers.MakeFooter(),
-		})
+	data := map[string]interface{}{
+		"playlist":  playlist,
+		"unwatched": origs,
+		"watched":   watchedOrigs,
+		"Footer":    handlers.MakeFooter(),
+	}
+	return c.Render(http.StatusOK, "playlist.html", data)
 }
 
 func deletePlaylistHandler(c echo.Context) error {
-	id := c.Param("id")
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+
+	if _, err := authz.Playlist(c.Get("user_id").(uint), uint(id)); err != nil {
+		return c.String(http.StatusNotFound, "no such playlist")
+	}
 
 	// delete all originals
 	var origs []originals.Original
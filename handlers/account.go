@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+	"rsc.io/qr"
+
+	"ytdlp-site/database"
+	"ytdlp-site/profiles"
+	"ytdlp-site/totp"
+	"ytdlp-site/users"
+)
+
+// render the account page, with any extra data for the template
+func renderAccount(c echo.Context, status int, data map[string]interface{}) error {
+	userID := c.Get("user_id").(uint)
+	db := database.Get()
+
+	var user users.User
+	db.First(&user, userID)
+	var sessions []users.Session
+	db.Where("user_id = ?", userID).Order("last_seen DESC").Find(&sessions)
+
+	var currentID uint
+	if s, err := currentSession(c); err == nil {
+		currentID = s.ID
+	}
+
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data["user"] = user
+	data["sessions"] = sessions
+	data["currentID"] = currentID
+	data["recoveryCodesLeft"] = users.CountRecoveryCodes(db, userID)
+	if profileList, err := profiles.List(db); err == nil {
+		data["profiles"] = profileList
+	}
+	data["Footer"] = MakeFooter()
+	return c.Render(status, "account.html", data)
+}
+
+func message(msg string) map[string]interface{} {
+	return map[string]interface{}{"message": msg}
+}
+
+func AccountGet(c echo.Context) error {
+	return renderAccount(c, http.StatusOK, nil)
+}
+
+func AccountPasswordPost(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	current := c.FormValue("current_password")
+	password := c.FormValue("password")
+	confirm := c.FormValue("confirm_password")
+	db := database.Get()
+
+	var user users.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return c.String(http.StatusInternalServerError, "Unable to retrieve user")
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(current)); err != nil {
+		return renderAccount(c, http.StatusBadRequest, message("Current password is incorrect"))
+	}
+	if password == "" || password != confirm {
+		return renderAccount(c, http.StatusBadRequest, message("New passwords do not match"))
+	}
+	if err := users.SetPassword(db, userID, password); err != nil {
+		log.Errorln("couldn't set password for user", userID, err)
+		return renderAccount(c, http.StatusInternalServerError, message("Unable to change password"))
+	}
+
+	// anyone holding an old session has to log in with the new password
+	if s, err := currentSession(c); err == nil {
+		db.Unscoped().Where("user_id = ? AND id != ?", userID, s.ID).Delete(&users.Session{})
+	} else {
+		users.DeleteSessions(db, userID)
+	}
+	return renderAccount(c, http.StatusOK, message("Password changed, other devices have been logged out"))
+}
+
+// choose the transcode profile used for the user's new downloads
+func AccountProfilePost(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	db := database.Get()
+
+	var profileID uint
+	if s := c.FormValue("profile_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return renderAccount(c, http.StatusBadRequest, message("Invalid profile"))
+		}
+		if _, err := profiles.Get(db, uint(id)); err != nil {
+			return renderAccount(c, http.StatusBadRequest, message("No such profile"))
+		}
+		profileID = uint(id)
+	}
+	if err := db.Model(&users.User{}).Where("id = ?", userID).Update("profile_id", profileID).Error; err != nil {
+		log.Errorln("couldn't set profile for user", userID, err)
+		return renderAccount(c, http.StatusInternalServerError, message("Unable to change profile"))
+	}
+	return renderAccount(c, http.StatusOK, message("Transcode profile saved"))
+}
+
+func AccountLogoutAllPost(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	if err := users.DeleteSessions(database.Get(), userID); err != nil {
+		log.Errorln("couldn't delete sessions for user", userID, err)
+	}
+	endSession(c)
+	return c.Redirect(http.StatusSeeOther, "/login")
+}
+
+func AccountSessionDeletePost(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+
+	err := database.Get().Unscoped().
+		Where("id = ? AND user_id = ?", id, userID).
+		Delete(&users.Session{}).Error
+	if err != nil {
+		log.Errorln("couldn't delete session", id, err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/account")
+}
+
+// template data to enroll secret in an authenticator app: the secret, its
+// otpauth:// link and a QR code of the link as a PNG data URI. The URLs are
+// marked safe, since html/template would otherwise reject their schemes
+func totpEnrollment(user users.User, secret string) map[string]interface{} {
+	uri := totp.URI(totpIssuer, user.Username, secret)
+	data := map[string]interface{}{
+		"totpSecret": secret,
+		"totpURI":    template.URL(uri),
+	}
+	if code, err := qr.Encode(uri, qr.M); err != nil {
+		log.Errorln("couldn't encode TOTP QR code", err)
+	} else {
+		code.Scale = 4
+		data["totpQR"] = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(code.PNG()))
+	}
+	return data
+}
+
+// start 2FA enrollment by showing a new secret
+func AccountTOTPSetupPost(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	db := database.Get()
+
+	var user users.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return c.String(http.StatusInternalServerError, "Unable to retrieve user")
+	}
+	if user.TOTPEnabled {
+		return renderAccount(c, http.StatusBadRequest, message("Two-factor authentication is already enabled"))
+	}
+
+	secret, err := users.BeginTOTP(db, userID)
+	if err != nil {
+		log.Errorln("couldn't create TOTP secret for user", userID, err)
+		return renderAccount(c, http.StatusInternalServerError, message("Unable to set up two-factor authentication"))
+	}
+	return renderAccount(c, http.StatusOK, totpEnrollment(user, secret))
+}
+
+// finish enrollment by checking a code from the authenticator
+func AccountTOTPEnablePost(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	db := database.Get()
+
+	var user users.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return c.String(http.StatusInternalServerError, "Unable to retrieve user")
+	}
+	codes, err := users.EnableTOTP(db, &user, c.FormValue("code"), clock())
+	if err != nil {
+		data := totpEnrollment(user, user.TOTPSecret)
+		data["message"] = "Invalid code, try again"
+		return renderAccount(c, http.StatusBadRequest, data)
+	}
+	return renderAccount(c, http.StatusOK, map[string]interface{}{
+		"message":       "Two-factor authentication enabled",
+		"recoveryCodes": codes,
+	})
+}
+
+func AccountTOTPDisablePost(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	db := database.Get()
+
+	var user users.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return c.String(http.StatusInternalServerError, "Unable to retrieve user")
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(c.FormValue("current_password"))); err != nil {
+		return renderAccount(c, http.StatusBadRequest, message("Current password is incorrect"))
+	}
+	if err := users.DisableTOTP(db, userID); err != nil {
+		log.Errorln("couldn't disable TOTP for user", userID, err)
+		return renderAccount(c, http.StatusInternalServerError, message("Unable to disable two-factor authentication"))
+	}
+	return renderAccount(c, http.StatusOK, message("Two-factor authentication disabled"))
+}
+
+func AccountRecoveryCodesPost(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	db := database.Get()
+
+	var user users.User
+	if err := db.First(&user, userID).Error; err != nil {
+		return c.String(http.StatusInternalServerError, "Unable to retrieve user")
+	}
+	if !user.TOTPEnabled || !users.VerifyTOTP(db, &user, c.FormValue("code"), clock()) {
+		return renderAccount(c, http.StatusBadRequest, message("Invalid code"))
+	}
+	codes, err := users.NewRecoveryCodes(db, userID)
+	if err != nil {
+		log.Errorln("couldn't create recovery codes for user", userID, err)
+		return renderAccount(c, http.StatusInternalServerError, message("Unable to create recovery codes"))
+	}
+	return renderAccount(c, http.StatusOK, map[string]interface{}{
+		"message":       "New recovery codes created, the old ones no longer work",
+		"recoveryCodes": codes,
+	})
+}
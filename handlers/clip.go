@@ -1,58 +1,252 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
+	"ytdlp-site/authz"
 	"ytdlp-site/config"
 	"ytdlp-site/database"
+	"ytdlp-site/diskspace"
 	"ytdlp-site/ffmpeg"
 	"ytdlp-site/media"
+	"ytdlp-site/quota"
+	"ytdlp-site/transcodes"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
-func ClipPost(c echo.Context) error {
+var ErrClipRange = errors.New("a clip must end after it starts")
 
-	videoID := c.FormValue("video_id")
+// ParseClipTime parses seconds ("90.5") or [h:]mm:ss[.frac] ("1:30.5")
+func ParseClipTime(s string) (float64, error) {
+	var secs float64
+	for _, part := range strings.Split(strings.TrimSpace(s), ":") {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		secs = secs*60 + v
+	}
+	return secs, nil
+}
 
-	fromSecs, err := strconv.ParseFloat(c.FormValue("from_secs"), 64)
-	if err != nil {
-		return err
+// ClipRange clamps [from, to] seconds to the length of src,
+// failing with ErrClipRange if nothing is left
+func ClipRange(src media.MediaFile, from, to float64) (float64, float64, error) {
+	from = max(from, 0)
+	if src.Length > 0 {
+		to = min(to, src.Length)
 	}
-	if fromSecs < 0 {
-		fromSecs = 0
+	if to <= from {
+		return from, to, ErrClipRange
 	}
-	toSecs, err := strconv.ParseFloat(c.FormValue("to_secs"), 64)
+	return from, to, nil
+}
+
+// clip [from, to] seconds out of the media file src (relative to the data
+// directory), clamped to its length. Returns the clip with its filename and size
+func clipFile(src media.MediaFile, from, to float64, reencode bool) (media.MediaFile, uint, uint, error) {
+	from, to, err := ClipRange(src, from, to)
 	if err != nil {
-		return err
+		return src, 0, 0, err
 	}
 
-	var video media.Video
-	err = database.Get().Where("id = ?", videoID).First(&video).Error
-	if err != nil {
-		return err
+	if reencode {
+		// as heavy as a transcode, so it waits for one of their slots
+		transcodes.Slots <- struct{}{}
+		defer func() { <-transcodes.Slots }()
+	}
+	// deferred while the disk is nearly full, like downloads and transcodes
+	for !diskspace.Wait(time.Minute) {
 	}
 
-	dstBase := uuid.Must(uuid.NewV7()).String()
-	dstName := dstBase + filepath.Ext(video.Filename)
+	dstName := uuid.Must(uuid.NewV7()).String() + filepath.Ext(src.Filename)
 	dstPath := filepath.Join(config.GetDataDir(), dstName)
-
-	srcPath := filepath.Join(config.GetDataDir(), video.Filename)
-	log.Debugf("Clip from %s [%f-%f]", srcPath, fromSecs, toSecs)
-	err = ffmpeg.Clip(srcPath, dstPath, fromSecs, toSecs)
+	srcPath := filepath.Join(config.GetDataDir(), src.Filename)
+	log.Debugf("Clip from %s [%f-%f] reencode=%t", srcPath, from, to, reencode)
+	if err := ffmpeg.Clip(srcPath, dstPath, from, to, reencode); err != nil {
+		os.Remove(dstPath)
+		return src, 0, 0, err
+	}
+	info, err := os.Stat(dstPath)
 	if err != nil {
-		return err
+		return src, 0, 0, err
 	}
 
+	clip := src
+	clip.Filename = dstName
+	clip.Size = info.Size()
+	clip.Length = to - from
+	return clip, uint(from*1000 + 0.5), uint(to*1000 + 0.5), nil
+}
+
+// NewVideoClip clips [from, to] seconds out of video
+func NewVideoClip(video media.Video, from, to float64, precise bool, title string) (media.VideoClip, error) {
+	file, startMS, stopMS, err := clipFile(video.MediaFile, from, to, precise)
+	if err != nil {
+		return media.VideoClip{}, err
+	}
 	clip := media.VideoClip{
 		VideoFile:  video.VideoFile,
 		OriginalID: video.OriginalID,
 		VideoID:    video.ID,
-		StartMS:    uint(fromSecs*1000 + 0.5),
-		StopMS:     uint(toSecs*1000 + 0.5),
+		Title:      title,
+		StartMS:    startMS,
+		StopMS:     stopMS,
 	}
-	clip.Filename = dstName
+	clip.MediaFile = file
+	if precise {
+		clip.Codec = ffmpeg.ClipCodec(file.Filename)
+	}
+	return clip, database.Get().Create(&clip).Error
+}
+
+// NewAudioClip clips [from, to] seconds out of audio. Its streams are
+// copied, which is already precise for audio
+func NewAudioClip(audio media.Audio, from, to float64, title string) (media.AudioClip, error) {
+	file, startMS, stopMS, err := clipFile(audio.MediaFile, from, to, false)
+	if err != nil {
+		return media.AudioClip{}, err
+	}
+	clip := media.AudioClip{
+		MediaFile:  file,
+		OriginalID: audio.OriginalID,
+		AudioID:    audio.ID,
+		Title:      title,
+		StartMS:    startMS,
+		StopMS:     stopMS,
+	}
+	return clip, database.Get().Create(&clip).Error
+}
+
+func removeClipFile(filename string) {
+	path := filepath.Join(config.GetDataDir(), filename)
+	log.Debugln("remove clip", path)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Errorln("error removing", path, err)
+	}
+}
+
+// DeleteVideoClip removes a video clip and its file
+func DeleteVideoClip(clip media.VideoClip) error {
+	removeClipFile(clip.Filename)
+	return database.Get().Delete(&media.VideoClip{}, clip.ID).Error
+}
+
+// DeleteAudioClip removes an audio clip and its file
+func DeleteAudioClip(clip media.AudioClip) error {
+	removeClipFile(clip.Filename)
+	return database.Get().Delete(&media.AudioClip{}, clip.ID).Error
+}
+
+// DeleteClips removes all the clips of an original and their files
+func DeleteClips(originalID uint) {
+	db := database.Get()
+	var videoClips []media.VideoClip
+	db.Where("original_id = ?", originalID).Find(&videoClips)
+	for _, clip := range videoClips {
+		removeClipFile(clip.Filename)
+	}
+	db.Delete(&media.VideoClip{}, "original_id = ?", originalID)
+
+	var audioClips []media.AudioClip
+	db.Where("original_id = ?", originalID).Find(&audioClips)
+	for _, clip := range audioClips {
+		removeClipFile(clip.Filename)
+	}
+	db.Delete(&media.AudioClip{}, "original_id = ?", originalID)
+}
+
+// ClipPost clips the video_id video or audio_id audio from from_secs to to_secs,
+// re-encoding if precise is set. Clipping continues in the background
+func ClipPost(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
 
-	return database.Get().Create(&clip).Error
+	fromSecs, err := ParseClipTime(c.FormValue("from_secs"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	toSecs, err := ParseClipTime(c.FormValue("to_secs"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	if toSecs <= fromSecs {
+		return c.String(http.StatusBadRequest, ErrClipRange.Error())
+	}
+	precise := c.FormValue("precise") == "on"
+	title := strings.TrimSpace(c.FormValue("title"))
+
+	var originalID uint
+	var src media.MediaFile
+	var run func() error
+	if c.FormValue("audio_id") != "" {
+		audioID, _ := strconv.ParseUint(c.FormValue("audio_id"), 10, 64)
+		audio, err := authz.Audio(userID, uint(audioID))
+		if err != nil {
+			return c.String(http.StatusNotFound, "no such audio")
+		}
+		originalID, src = audio.OriginalID, audio.MediaFile
+		run = func() error {
+			_, err := NewAudioClip(audio, fromSecs, toSecs, title)
+			return err
+		}
+	} else {
+		videoID, _ := strconv.ParseUint(c.FormValue("video_id"), 10, 64)
+		video, err := authz.Video(userID, uint(videoID))
+		if err != nil {
+			return c.String(http.StatusNotFound, "no such video")
+		}
+		originalID, src = video.OriginalID, video.MediaFile
+		run = func() error {
+			_, err := NewVideoClip(video, fromSecs, toSecs, precise, title)
+			return err
+		}
+	}
+	if _, _, err := ClipRange(src, fromSecs, toSecs); err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	if err := quota.CheckTranscode(userID); errors.Is(err, quota.ErrExceeded) {
+		return c.String(http.StatusForbidden, err.Error())
+	} else if err != nil {
+		log.Errorln(err)
+	}
+
+	go func() {
+		if err := run(); err != nil {
+			log.Errorln("clip of original", originalID, err)
+		}
+	}()
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/video/%d", originalID))
+}
+
+func VideoClipDelete(c echo.Context) error {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	clip, err := authz.VideoClip(c.Get("user_id").(uint), uint(id))
+	if err != nil {
+		return c.String(http.StatusNotFound, "no such clip")
+	}
+	if err := DeleteVideoClip(clip); err != nil {
+		log.Errorln("delete clip error", id, err)
+	}
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/video/%d", clip.OriginalID))
+}
+
+func AudioClipDelete(c echo.Context) error {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	clip, err := authz.AudioClip(c.Get("user_id").(uint), uint(id))
+	if err != nil {
+		return c.String(http.StatusNotFound, "no such clip")
+	}
+	if err := DeleteAudioClip(clip); err != nil {
+		log.Errorln("delete clip error", id, err)
+	}
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/video/%d", clip.OriginalID))
 }
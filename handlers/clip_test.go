@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	"ytdlp-site/media"
+)
+
+func TestParseClipTime(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"90", 90, true},
+		{"90.5", 90.5, true},
+		{" 90 ", 90, true},
+		{"1:30", 90, true},
+		{"1:30.5", 90.5, true},
+		{"01:02:03", 3723, true},
+		{"0:00", 0, true},
+		{"1:75", 135, true}, // not range checked, like ffmpeg
+		{"", 0, false},
+		{"abc", 0, false},
+		{"1:", 0, false},
+		{":30", 0, false},
+		{"-5", 0, false},
+		{"1:-5", 0, false},
+	}
+	for _, tc := range tests {
+		got, err := ParseClipTime(tc.in)
+		if (err == nil) != tc.ok {
+			t.Errorf("ParseClipTime(%q) error = %v, want ok %t", tc.in, err, tc.ok)
+		} else if tc.ok && got != tc.want {
+			t.Errorf("ParseClipTime(%q) = %f, want %f", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestClipRange(t *testing.T) {
+	tests := []struct {
+		name             string
+		length, from, to float64
+		wantFrom, wantTo float64
+		err              bool
+	}{
+		{"inside", 60, 10, 20, 10, 20, false},
+		{"past the end", 60, 50, 90, 50, 60, false},
+		{"before the start", 60, -5, 20, 0, 20, false},
+		{"unknown length", 0, 50, 90, 50, 90, false},
+		{"starts at the end", 60, 60, 90, 0, 0, true},
+		{"starts after the end", 60, 70, 90, 0, 0, true},
+		{"empty", 60, 20, 20, 0, 0, true},
+		{"backwards", 60, 20, 10, 0, 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			from, to, err := ClipRange(media.MediaFile{Length: tc.length}, tc.from, tc.to)
+			if tc.err {
+				if !errors.Is(err, ErrClipRange) {
+					t.Errorf("err = %v, want ErrClipRange", err)
+				}
+				return
+			}
+			if err != nil || from != tc.wantFrom || to != tc.wantTo {
+				t.Errorf("got [%f, %f] %v, want [%f, %f]", from, to, err, tc.wantFrom, tc.wantTo)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"ytdlp-site/authz"
+	"ytdlp-site/database"
+	"ytdlp-site/playlists"
+	"ytdlp-site/users"
+)
+
+// SiteURL is the base URL of the site as seen by the client
+func SiteURL(c echo.Context) string {
+	return c.Scheme() + "://" + c.Request().Host
+}
+
+// FeedURL is the podcast feed of a user's audio, or of a playlist if playlistID isn't 0
+func FeedURL(c echo.Context, key string, playlistID uint) string {
+	if playlistID != 0 {
+		return fmt.Sprintf("%s/feed/%s/playlists/%d", SiteURL(c), key, playlistID)
+	}
+	return fmt.Sprintf("%s/feed/%s/audio", SiteURL(c), key)
+}
+
+// a playlist's podcast feed, for listing on the account page
+type playlistFeed struct {
+	Title string
+	URL   string
+}
+
+// replace the feed key, so the old feed URLs stop working. The new URLs
+// are only shown now, since the key is stored hashed
+func AccountFeedResetPost(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	db := database.Get()
+	key, err := users.ResetFeedKey(db, userID)
+	if err != nil {
+		log.Errorln("couldn't reset feed key for user", userID, err)
+		return renderAccount(c, http.StatusInternalServerError, message("Unable to reset feed link"))
+	}
+
+	var pls []playlists.Playlist
+	if err := db.Scopes(authz.Owner(userID)).Order("id ASC").Find(&pls).Error; err != nil {
+		log.Errorln("couldn't list playlists for user", userID, err)
+	}
+	var playlistFeeds []playlistFeed
+	for _, pl := range pls {
+		title := pl.Title
+		if title == "" {
+			title = pl.URL
+		}
+		playlistFeeds = append(playlistFeeds, playlistFeed{Title: title, URL: FeedURL(c, key, pl.ID)})
+	}
+
+	data := message("New feed links created, any old ones no longer work")
+	data["feedURL"] = FeedURL(c, key, 0)
+	data["playlistFeeds"] = playlistFeeds
+	return renderAccount(c, http.StatusOK, data)
+}
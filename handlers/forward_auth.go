@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"ytdlp-site/config"
+	"ytdlp-site/database"
+	"ytdlp-site/users"
+)
+
+// set by Init from config
+var authHeader string
+var trustedProxies []*net.IPNet
+
+func initForwardAuth() error {
+	authHeader = config.GetAuthHeader()
+	nets, err := config.GetTrustedProxies()
+	if err != nil {
+		return err
+	}
+	trustedProxies = nets
+	if authHeader != "" {
+		if len(trustedProxies) == 0 {
+			return fmt.Errorf("YTDLP_SITE_AUTH_HEADER requires YTDLP_SITE_TRUSTED_PROXIES")
+		}
+		log.Infof("trusting %s header from %v", authHeader, trustedProxies)
+	}
+	return nil
+}
+
+// ForwardAuthEnabled is true if users are authenticated by a reverse proxy
+// instead of logging in here
+func ForwardAuthEnabled() bool {
+	return authHeader != ""
+}
+
+// IPExtractor finds the client address for c.RealIP(). X-Forwarded-For is only
+// believed when it was set by one of the trusted proxies, otherwise a client
+// could pick its own address to get around login throttling
+func IPExtractor() echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, n := range trustedProxies {
+		options = append(options, echo.TrustIPRange(n))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// whether the request came directly from a trusted proxy.
+// Deliberately ignores X-Forwarded-For, which the client controls
+func fromTrustedProxy(c echo.Context) bool {
+	host, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+	if err != nil {
+		host = c.Request().RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// the user named by the auth header, provisioning them if needed
+func forwardAuthUser(c echo.Context) (users.User, error) {
+	if !fromTrustedProxy(c) {
+		log.Warnf("ignoring request from untrusted address %s", c.Request().RemoteAddr)
+		return users.User{}, errNotLoggedIn
+	}
+	username := strings.TrimSpace(c.Request().Header.Get(authHeader))
+	if username == "" {
+		return users.User{}, errNotLoggedIn
+	}
+	user, err := users.Provision(database.Get(), username)
+	if err != nil {
+		log.Errorf("couldn't provision user %q: %v", username, err)
+		return users.User{}, err
+	}
+	return user, nil
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestIPExtractor(t *testing.T) {
+	defer func(saved []*net.IPNet) { trustedProxies = saved }(trustedProxies)
+	_, proxy, _ := net.ParseCIDR("10.0.0.1/32")
+
+	tests := []struct {
+		name    string
+		proxies []*net.IPNet
+		remote  string
+		xff     string
+		want    string
+	}{
+		{"no proxies ignores the header", nil, "10.0.0.1:1234", "1.2.3.4", "10.0.0.1"},
+		{"no proxies ignores private addresses", nil, "192.168.1.5:1234", "1.2.3.4", "192.168.1.5"},
+		{"trusted proxy", []*net.IPNet{proxy}, "10.0.0.1:1234", "1.2.3.4", "1.2.3.4"},
+		{"untrusted private address", []*net.IPNet{proxy}, "10.0.0.2:1234", "1.2.3.4", "10.0.0.2"},
+		{"untrusted loopback", []*net.IPNet{proxy}, "127.0.0.1:1234", "1.2.3.4", "127.0.0.1"},
+		{"spoofed hop before the proxy", []*net.IPNet{proxy}, "10.0.0.1:1234", "5.6.7.8, 1.2.3.4", "1.2.3.4"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			trustedProxies = tc.proxies
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tc.remote
+			req.Header.Set(echo.HeaderXForwardedFor, tc.xff)
+			if got := IPExtractor()(req); got != tc.want {
+				t.Errorf("got %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -28,7 +28,7 @@ func Init(logger *logrus.Logger) error {
 		Secure:   config.GetSecure(),
 	}
 
-	return nil
+	return initForwardAuth()
 }
 
 func Fini() {}
@@ -2,7 +2,11 @@ package handlers
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 	"ytdlp-site/database"
 	"ytdlp-site/users"
 
@@ -10,36 +14,74 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	totpIssuer = "ytdlp-site"
+	// time allowed between entering the password and the 2FA code
+	totpLoginTimeout = 5 * time.Minute
+)
+
+// the time used to check TOTP codes, replaceable for tests
+var clock = time.Now
+
+func tooManyAttempts(c echo.Context, wait time.Duration) error {
+	secs := int(math.Ceil(wait.Seconds()))
+	c.Response().Header().Set("Retry-After", strconv.Itoa(secs))
+	return c.String(http.StatusTooManyRequests,
+		fmt.Sprintf("Too many failed logins, try again in %d minutes", (secs+59)/60))
+}
+
 func LoginPost(c echo.Context) error {
+	if ForwardAuthEnabled() {
+		return c.Redirect(http.StatusSeeOther, "/videos")
+	}
 	username := c.FormValue("username")
 	password := c.FormValue("password")
 
+	// usernames are case-sensitive, but don't allow case variations to dodge the limit
+	userKey := strings.ToLower(username)
+	ip := c.RealIP()
+	if wait := max(usernameThrottle.Wait(userKey), ipThrottle.Wait(ip)); wait > 0 {
+		log.Warnf("throttled login for %q from %s", username, ip)
+		return tooManyAttempts(c, wait)
+	}
+	fail := func() error {
+		usernameThrottle.Fail(userKey)
+		ipThrottle.Fail(ip)
+		return c.String(http.StatusUnauthorized, "Invalid credentials")
+	}
+
 	db := database.Get()
 
 	var user users.User
 	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
-		return c.String(http.StatusUnauthorized, "Invalid credentials")
+		return fail()
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return c.String(http.StatusUnauthorized, "Invalid credentials")
+		return fail()
 	}
-
-	session, err := store.Get(c.Request(), "session")
-	if err != nil {
-		return c.String(http.StatusInternalServerError, "Unable to retrieve session")
+	if user.Disabled {
+		return c.String(http.StatusForbidden, "Account disabled")
 	}
-	session.Values["user_id"] = user.ID
-	err = session.Save(c.Request(), c.Response().Writer)
 
-	if err != nil {
-		return c.String(http.StatusInternalServerError, "Unable to save session")
+	if user.TOTPEnabled {
+		// remember who entered their password, and ask for the code
+		session, err := store.Get(c.Request(), "session")
+		if err != nil {
+			return c.String(http.StatusInternalServerError, "Unable to retrieve session")
+		}
+		session.Values["totp_user_id"] = user.ID
+		session.Values["totp_expires"] = clock().Add(totpLoginTimeout).Unix()
+		if err := session.Save(c.Request(), c.Response().Writer); err != nil {
+			return c.String(http.StatusInternalServerError, "Unable to save session")
+		}
+		return c.Redirect(http.StatusSeeOther, "/login/totp")
 	}
+	usernameThrottle.Reset(userKey)
 
-	session, _ = store.Get(c.Request(), "session")
-	_, ok := session.Values["user_id"]
-	if !ok {
-		return c.String(http.StatusInternalServerError, "user_id was not saved as expected")
+	if err := startSession(c, user.ID); err != nil {
+		log.Errorln("couldn't start session:", err)
+		return c.String(http.StatusInternalServerError, "Unable to save session")
 	}
 
 	fmt.Println("loginPostHandler: redirect to /download")
@@ -47,12 +89,81 @@ func LoginPost(c echo.Context) error {
 }
 
 func LoginGet(c echo.Context) error {
+	if ForwardAuthEnabled() {
+		// the proxy has already logged the user in
+		return c.Redirect(http.StatusSeeOther, "/videos")
+	}
 	return c.Render(http.StatusOK, "login.html", nil)
 }
 
 func LogoutGet(c echo.Context) error {
-	session, _ := store.Get(c.Request(), "session")
-	delete(session.Values, "user_id")
-	session.Save(c.Request(), c.Response().Writer)
+	if ForwardAuthEnabled() {
+		// logging out is up to the proxy
+		return c.Redirect(http.StatusSeeOther, "/videos")
+	}
+	if err := endSession(c); err != nil {
+		log.Errorln("couldn't end session:", err)
+	}
 	return c.Redirect(http.StatusSeeOther, "/login")
 }
+
+// the user who has entered their password but not yet their 2FA code
+func pendingTOTPUser(c echo.Context) (users.User, error) {
+	session, err := store.Get(c.Request(), "session")
+	if err != nil {
+		return users.User{}, err
+	}
+	userID, ok := session.Values["totp_user_id"].(uint)
+	expires, ok2 := session.Values["totp_expires"].(int64)
+	if !ok || !ok2 || clock().Unix() > expires {
+		return users.User{}, errNotLoggedIn
+	}
+
+	var user users.User
+	if err := database.Get().First(&user, userID).Error; err != nil {
+		return users.User{}, err
+	}
+	return user, nil
+}
+
+func LoginTOTPGet(c echo.Context) error {
+	if _, err := pendingTOTPUser(c); err != nil {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+	return c.Render(http.StatusOK, "login_totp.html", nil)
+}
+
+func LoginTOTPPost(c echo.Context) error {
+	user, err := pendingTOTPUser(c)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/login")
+	}
+
+	userKey := strings.ToLower(user.Username)
+	ip := c.RealIP()
+	if wait := max(usernameThrottle.Wait(userKey), ipThrottle.Wait(ip)); wait > 0 {
+		log.Warnf("throttled 2FA for %q from %s", user.Username, ip)
+		return tooManyAttempts(c, wait)
+	}
+
+	db := database.Get()
+	code := c.FormValue("code")
+	if !users.VerifyTOTP(db, &user, code, clock()) && !users.UseRecoveryCode(db, user.ID, code) {
+		usernameThrottle.Fail(userKey)
+		ipThrottle.Fail(ip)
+		return c.String(http.StatusUnauthorized, "Invalid code")
+	}
+	if user.Disabled {
+		return c.String(http.StatusForbidden, "Account disabled")
+	}
+	usernameThrottle.Reset(userKey)
+
+	session, _ := store.Get(c.Request(), "session")
+	delete(session.Values, "totp_user_id")
+	delete(session.Values, "totp_expires")
+	if err := startSession(c, user.ID); err != nil {
+		log.Errorln("couldn't start session:", err)
+		return c.String(http.StatusInternalServerError, "Unable to save session")
+	}
+	return c.Redirect(http.StatusSeeOther, "/download")
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+
+	"ytdlp-site/database/dbtest"
+	"ytdlp-site/totp"
+	"ytdlp-site/users"
+)
+
+// a browser going through the login pages, keeping its cookies
+type loginClient struct {
+	e       *echo.Echo
+	cookies []*http.Cookie
+}
+
+func (lc *loginClient) post(target string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	for _, cookie := range lc.cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	lc.e.ServeHTTP(rec, req)
+	if cookies := rec.Result().Cookies(); len(cookies) > 0 {
+		lc.cookies = cookies
+	}
+	return rec
+}
+
+type totpLoginTest struct {
+	db            *gorm.DB
+	e             *echo.Echo
+	now           time.Time // the time seen by the handlers
+	user          users.User
+	recoveryCodes []string
+}
+
+// a user with TOTP enabled, the login routes, and a fixed clock
+func setupTOTPLogin(t *testing.T) *totpLoginTest {
+	t.Helper()
+	t.Setenv("YTDLP_SITE_SESSION_AUTH_KEY", "test")
+	testLog := logrus.New()
+	testLog.SetLevel(logrus.WarnLevel)
+	if err := Init(testLog); err != nil {
+		t.Fatal(err)
+	}
+
+	db := dbtest.Open(t, &users.User{}, &users.Session{}, &users.RecoveryCode{})
+
+	lt := &totpLoginTest{db: db, now: time.Unix(1700000000, 0)}
+	savedClock := clock
+	clock = func() time.Time { return lt.now }
+	usernameThrottle = newLoginThrottle(maxUsernameFailures)
+	ipThrottle = newLoginThrottle(maxIPFailures)
+	t.Cleanup(func() { clock = savedClock })
+
+	var err error
+	lt.user, err = users.CreateUser(db, "alice", "password", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := users.BeginTOTP(db, lt.user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lt.user.TOTPSecret = secret
+	// enrolled a while ago, so the enrollment code doesn't block current ones
+	enrolled := lt.now.Add(-time.Hour)
+	code, _ := totp.Code(secret, enrolled)
+	lt.recoveryCodes, err = users.EnableTOTP(db, &lt.user, code, enrolled)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lt.e = echo.New()
+	lt.e.POST("/login", LoginPost)
+	lt.e.POST("/login/totp", LoginTOTPPost)
+	return lt
+}
+
+// enter the password, which should lead to the 2FA step
+func (lt *totpLoginTest) startLogin(t *testing.T) *loginClient {
+	t.Helper()
+	lc := &loginClient{e: lt.e}
+	rec := lc.post("/login", url.Values{"username": {"alice"}, "password": {"password"}})
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login/totp" {
+		t.Fatalf("password step: status %d to %q, want 303 to /login/totp", rec.Code, rec.Header().Get("Location"))
+	}
+	return lc
+}
+
+func (lt *totpLoginTest) code(t *testing.T, at time.Time) string {
+	t.Helper()
+	code, err := totp.Code(lt.user.TOTPSecret, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func (lt *totpLoginTest) sessions() int64 {
+	var n int64
+	lt.db.Model(&users.Session{}).Where("user_id = ?", lt.user.ID).Count(&n)
+	return n
+}
+
+func assertLoggedIn(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/download" {
+		t.Errorf("2FA step: status %d to %q, want 303 to /download", rec.Code, rec.Header().Get("Location"))
+	}
+}
+
+func TestLoginTOTPValidCode(t *testing.T) {
+	lt := setupTOTPLogin(t)
+	lc := lt.startLogin(t)
+	if n := lt.sessions(); n != 0 {
+		t.Fatalf("%d sessions after only the password", n)
+	}
+	assertLoggedIn(t, lc.post("/login/totp", url.Values{"code": {lt.code(t, lt.now)}}))
+	if n := lt.sessions(); n != 1 {
+		t.Errorf("%d sessions after logging in, want 1", n)
+	}
+}
+
+func TestLoginTOTPExpiredCode(t *testing.T) {
+	lt := setupTOTPLogin(t)
+
+	// a code from outside the clock drift window
+	lc := lt.startLogin(t)
+	stale := lt.code(t, lt.now.Add(-(totp.Skew+1)*totp.Period))
+	if rec := lc.post("/login/totp", url.Values{"code": {stale}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("stale code: status %d, want 401", rec.Code)
+	}
+
+	// a current code, after the time allowed since the password ran out
+	lt.now = lt.now.Add(totpLoginTimeout + time.Second)
+	rec := lc.post("/login/totp", url.Values{"code": {lt.code(t, lt.now)}})
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login" {
+		t.Errorf("expired login: status %d to %q, want 303 to /login", rec.Code, rec.Header().Get("Location"))
+	}
+	if n := lt.sessions(); n != 0 {
+		t.Errorf("%d sessions after failed logins", n)
+	}
+}
+
+func TestLoginTOTPReplayedCode(t *testing.T) {
+	lt := setupTOTPLogin(t)
+	code := lt.code(t, lt.now)
+	assertLoggedIn(t, lt.startLogin(t).post("/login/totp", url.Values{"code": {code}}))
+
+	// someone who saw the code tries it from another browser in the same step
+	lt.now = lt.now.Add(5 * time.Second)
+	if rec := lt.startLogin(t).post("/login/totp", url.Values{"code": {code}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("replayed code: status %d, want 401", rec.Code)
+	}
+	if n := lt.sessions(); n != 1 {
+		t.Errorf("%d sessions, want 1", n)
+	}
+}
+
+func TestLoginTOTPRecoveryCode(t *testing.T) {
+	lt := setupTOTPLogin(t)
+	code := lt.recoveryCodes[0]
+	assertLoggedIn(t, lt.startLogin(t).post("/login/totp", url.Values{"code": {code}}))
+	if n := users.CountRecoveryCodes(lt.db, lt.user.ID); n != int64(len(lt.recoveryCodes)-1) {
+		t.Errorf("%d recovery codes left, want %d", n, len(lt.recoveryCodes)-1)
+	}
+
+	if rec := lt.startLogin(t).post("/login/totp", url.Values{"code": {code}}); rec.Code != http.StatusUnauthorized {
+		t.Errorf("used recovery code: status %d, want 401", rec.Code)
+	}
+}
+
+func TestLoginTOTPLockout(t *testing.T) {
+	lt := setupTOTPLogin(t)
+	lc := lt.startLogin(t)
+	for i := 0; i < maxUsernameFailures; i++ {
+		if rec := lc.post("/login/totp", url.Values{"code": {"000000"}}); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong code %d: status %d, want 401", i+1, rec.Code)
+		}
+	}
+
+	// even the right code is refused while locked out
+	rec := lc.post("/login/totp", url.Values{"code": {lt.code(t, lt.now)}})
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("after %d wrong codes: status %d, want 429", maxUsernameFailures, rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+	if n := lt.sessions(); n != 0 {
+		t.Errorf("%d sessions while locked out", n)
+	}
+}
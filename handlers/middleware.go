@@ -3,23 +3,169 @@ package handlers
 import (
 	"fmt"
 	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"ytdlp-site/authz"
+	"ytdlp-site/database"
+	"ytdlp-site/users"
 
 	"github.com/labstack/echo/v4"
 )
 
-func AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		session, err := store.Get(c.Request(), "session")
+var errNotLoggedIn = fmt.Errorf("not logged in")
+
+// identify the requesting user from a bearer token, the forward-auth header,
+// or the session cookie. token is nil unless a bearer token was used
+func authenticate(c echo.Context) (users.User, *users.Token, error) {
+	db := database.Get()
+
+	var userID uint
+	var token *users.Token
+	auth := c.Request().Header.Get(echo.HeaderAuthorization)
+	if bearer, ok := strings.CutPrefix(auth, "Bearer "); ok {
+		t, err := users.LookupToken(db, strings.TrimSpace(bearer))
 		if err != nil {
-			return c.String(http.StatusInternalServerError, "Error: Unable to retrieve session")
+			return users.User{}, nil, errNotLoggedIn
+		}
+		userID, token = t.UserID, &t
+	} else if ForwardAuthEnabled() {
+		user, err := forwardAuthUser(c)
+		if err != nil {
+			return users.User{}, nil, err
 		}
-		userID, ok := session.Values["user_id"]
-		if !ok {
+		userID = user.ID
+	} else {
+		session, err := currentSession(c)
+		if err != nil {
+			return users.User{}, nil, err
+		}
+		userID = session.UserID
+	}
+
+	// deleted and disabled users are logged out immediately
+	var user users.User
+	if err := db.First(&user, userID).Error; err != nil || user.Disabled {
+		return users.User{}, nil, errNotLoggedIn
+	}
+	return user, token, nil
+}
+
+// record the authenticated user in the context
+func setUser(c echo.Context, user users.User, token *users.Token) {
+	c.Set("user_id", user.ID)
+	// tokens need the admin scope to use an admin's privileges
+	c.Set("is_admin", user.IsAdmin && (token == nil || token.HasScope(users.ScopeAdmin)))
+}
+
+// whether a token's scopes allow a request.
+// submit is true for routes that only enqueue downloads, which are the only
+// routes a submit-scoped token can use
+func tokenAllows(token *users.Token, method string, submit bool) bool {
+	if token.HasScope(users.ScopeAdmin) {
+		return true
+	}
+	if submit && token.HasScope(users.ScopeSubmit) {
+		return true
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return token.HasScope(users.ScopeRead)
+	}
+	return false
+}
+
+func authMiddleware(submit bool, next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, token, err := authenticate(c)
+		if err == errNotLoggedIn {
+			if c.Request().Header.Get(echo.HeaderAuthorization) != "" {
+				return c.String(http.StatusUnauthorized, "invalid token")
+			}
+			if ForwardAuthEnabled() {
+				return c.String(http.StatusUnauthorized, "not authenticated by proxy")
+			}
 			fmt.Println("authMiddleware: session does not contain user_id. Redirect to /login")
 			// return c.String(http.StatusForbidden, "not logged in")
 			return c.Redirect(http.StatusSeeOther, "/login")
+		} else if err != nil {
+			return c.String(http.StatusInternalServerError, "Error: Unable to retrieve session")
+		}
+		if token != nil && !tokenAllows(token, c.Request().Method, submit) {
+			return c.String(http.StatusForbidden, "token scope does not allow this request")
+		}
+		setUser(c, user, token)
+		return next(c)
+	}
+}
+
+func AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return authMiddleware(false, next)
+}
+
+// like AuthMiddleware, but also accepts tokens with the submit scope
+func SubmitAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return authMiddleware(true, next)
+}
+
+func apiAuthMiddleware(submit bool, next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, token, err := authenticate(c)
+		if err == errNotLoggedIn {
+			return echo.NewHTTPError(http.StatusUnauthorized, "not logged in")
+		} else if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "unable to retrieve session")
+		}
+		if token != nil && !tokenAllows(token, c.Request().Method, submit) {
+			return echo.NewHTTPError(http.StatusForbidden, "token scope does not allow this request")
+		}
+		setUser(c, user, token)
+		return next(c)
+	}
+}
+
+// like AuthMiddleware, but responds with an error instead of redirecting to /login
+func APIAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return apiAuthMiddleware(false, next)
+}
+
+// like APIAuthMiddleware, but also accepts tokens with the submit scope
+func APISubmitAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return apiAuthMiddleware(true, next)
+}
+
+// AdminMiddleware restricts a route to admins. Must run after AuthMiddleware
+func AdminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if isAdmin, _ := c.Get("is_admin").(bool); !isAdmin {
+			return c.String(http.StatusForbidden, "admin only")
+		}
+		return next(c)
+	}
+}
+
+// like AdminMiddleware, but responds with a JSON error. Must run after APIAuthMiddleware
+func APIAdminMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if isAdmin, _ := c.Get("is_admin").(bool); !isAdmin {
+			return echo.NewHTTPError(http.StatusForbidden, "admin only")
+		}
+		return next(c)
+	}
+}
+
+// DataMiddleware only serves files from the data directory that belong to
+// the requesting user. Must run after AuthMiddleware
+func DataMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID := c.Get("user_id").(uint)
+		name, err := url.PathUnescape(c.Param("*"))
+		if err != nil {
+			return echo.ErrNotFound
+		}
+		name = strings.TrimPrefix(path.Clean("/"+name), "/")
+		if !authz.OwnsFile(userID, name) {
+			return echo.ErrNotFound
 		}
-		c.Set("user_id", userID)
 		return next(c)
 	}
 }
@@ -2,25 +2,71 @@ package handlers
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/labstack/echo/v4"
+
+	"ytdlp-site/database"
+	"ytdlp-site/users"
 )
 
+const sessionTTL = 30 * 24 * time.Hour
+
 type User struct {
 	Id uint
 }
 
+// the server-side session referenced by the session cookie
+func currentSession(c echo.Context) (users.Session, error) {
+	session, err := store.Get(c.Request(), "session")
+	if err != nil {
+		return users.Session{}, fmt.Errorf("couldn't retrieve session from store")
+	}
+	key, ok := session.Values["session_key"].(string)
+	if !ok {
+		return users.Session{}, errNotLoggedIn
+	}
+	s, err := users.LookupSession(database.Get(), key)
+	if err != nil {
+		return users.Session{}, errNotLoggedIn
+	}
+	return s, nil
+}
+
 func GetUser(c echo.Context) (User, error) {
+	s, err := currentSession(c)
+	if err != nil {
+		return User{}, err
+	}
+	return User{Id: s.UserID}, nil
+}
+
+// create a session record for a user and point the session cookie at it
+func startSession(c echo.Context, userID uint) error {
+	s, err := users.CreateSession(database.Get(), userID,
+		c.Request().UserAgent(), c.RealIP(), sessionTTL)
+	if err != nil {
+		return err
+	}
+
 	session, err := store.Get(c.Request(), "session")
-	if err == nil {
-		val, ok := session.Values["user_id"]
-		if ok {
-			return User{Id: val.(uint)}, nil
-		} else {
-			return User{}, fmt.Errorf("user_id not in session")
-		}
-	} else {
-		return User{}, fmt.Errorf("couldn't retureve session from store")
+	if err != nil {
+		return err
 	}
+	delete(session.Values, "user_id") // from before server-side sessions
+	session.Values["session_key"] = s.Key
+	return session.Save(c.Request(), c.Response().Writer)
+}
 
+// delete the current session record and clear the session cookie
+func endSession(c echo.Context) error {
+	session, _ := store.Get(c.Request(), "session")
+	if key, ok := session.Values["session_key"].(string); ok {
+		if err := users.DeleteSession(database.Get(), key); err != nil {
+			log.Errorln("couldn't delete session", err)
+		}
+	}
+	delete(session.Values, "session_key")
+	delete(session.Values, "user_id")
+	return session.Save(c.Request(), c.Response().Writer)
 }
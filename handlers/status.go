@@ -8,30 +8,17 @@ import (
 	"sort"
 
 	"github.com/labstack/echo/v4"
-	"golang.org/x/sys/unix"
 	"gorm.io/gorm"
 
 	"ytdlp-site/config"
 	"ytdlp-site/database"
+	"ytdlp-site/diskspace"
 	"ytdlp-site/ffmpeg"
 	"ytdlp-site/media"
 	"ytdlp-site/originals"
 	"ytdlp-site/ytdlp"
 )
 
-// GetFreeSpace returns the free space in bytes for the filesystem containing the given directory
-func getFreeSpace(dir string) (int64, error) {
-	var stat unix.Statfs_t
-	err := unix.Statfs(dir, &stat)
-	if err != nil {
-		return 0, fmt.Errorf("error getting filesystem stats: %v", err)
-	}
-
-	// Calculate free space
-	freeSpace := int64(stat.Bavail) * int64(stat.Bsize)
-	return freeSpace, nil
-}
-
 type SizeEntry struct {
 	Name string
 	Size int64
@@ -68,6 +55,21 @@ func getOriginalId(db *gorm.DB, filename string) (uint, error) {
 	if result.Error == nil && result.RowsAffected == 1 {
 		return audio.OriginalID, nil
 	}
+	var clip media.VideoClip
+	result = db.Where("filename = ?", filename).First(&clip)
+	if result.Error == nil && result.RowsAffected == 1 {
+		return clip.OriginalID, nil
+	}
+	var subtitle media.Subtitle
+	result = db.Where("filename = ?", filename).First(&subtitle)
+	if result.Error == nil && result.RowsAffected == 1 {
+		return subtitle.OriginalID, nil
+	}
+	var audioClip media.AudioClip
+	result = db.Where("filename = ?", filename).First(&audioClip)
+	if result.Error == nil && result.RowsAffected == 1 {
+		return audioClip.OriginalID, nil
+	}
 
 	return 0, fmt.Errorf("no media found")
 }
@@ -93,7 +95,7 @@ func StatusGet(c echo.Context) error {
 		log.Errorln(err)
 	}
 
-	free, err := getFreeSpace(config.GetDataDir())
+	free, err := diskspace.Free(config.GetDataDir())
 	if err != nil {
 		log.Errorln(err)
 	}
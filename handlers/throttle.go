@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	loginWindow          = 15 * time.Minute // failures older than this are forgotten
+	loginLockout         = 15 * time.Minute
+	maxUsernameFailures  = 5
+	maxIPFailures        = 20
+	loginThrottleEntries = 10000 // forget everything rather than grow without bound
+)
+
+type loginFailures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+// counts failed logins per key and locks the key out after too many
+type loginThrottle struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]*loginFailures
+	now     func() time.Time
+}
+
+func newLoginThrottle(max int) *loginThrottle {
+	return &loginThrottle{
+		max:     max,
+		entries: map[string]*loginFailures{},
+		now:     time.Now,
+	}
+}
+
+// how long until key may attempt to log in again, zero if it may now
+func (t *loginThrottle) Wait(key string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[key]
+	if !ok {
+		return 0
+	}
+	if wait := e.lockedUntil.Sub(t.now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+func (t *loginThrottle) Fail(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+
+	if len(t.entries) >= loginThrottleEntries {
+		t.entries = map[string]*loginFailures{}
+	}
+
+	e, ok := t.entries[key]
+	if !ok || now.Sub(e.first) > loginWindow {
+		e = &loginFailures{first: now}
+		t.entries[key] = e
+	}
+	e.count += 1
+	if e.count >= t.max {
+		e.lockedUntil = now.Add(loginLockout)
+		e.count = 0
+		e.first = now
+	}
+}
+
+func (t *loginThrottle) Reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, key)
+}
+
+var usernameThrottle = newLoginThrottle(maxUsernameFailures)
+var ipThrottle = newLoginThrottle(maxIPFailures)
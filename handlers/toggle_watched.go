@@ -7,16 +7,19 @@ import (
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 
+	"ytdlp-site/authz"
 	"ytdlp-site/database"
 	"ytdlp-site/originals"
 )
 
 func ToggleWatched(c echo.Context) error {
 	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	userID := c.Get("user_id").(uint)
 
 	db := database.Get()
 
 	result := db.Model(&originals.Original{}).
+		Scopes(authz.Owner(userID)).
 		Where("id = ?", id).
 		Update("watched", gorm.Expr("NOT watched"))
 
@@ -25,7 +28,7 @@ func ToggleWatched(c echo.Context) error {
 	}
 
 	if result.RowsAffected == 0 {
-		log.Errorln(gorm.ErrRecordNotFound)
+		return c.String(http.StatusNotFound, "no such original")
 	}
 
 	referrer := c.Request().Referer()
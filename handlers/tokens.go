@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+
+	"ytdlp-site/database"
+	"ytdlp-site/users"
+)
+
+func parseScopes(strs []string) ([]users.Scope, error) {
+	var scopes []users.Scope
+	for _, s := range strs {
+		scope, err := users.ParseScope(s)
+		if err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, nil
+}
+
+func renderTokens(c echo.Context, newToken string) error {
+	userID := c.Get("user_id").(uint)
+
+	var tokens []users.Token
+	database.Get().Where("user_id = ?", userID).Order("id DESC").Find(&tokens)
+
+	return c.Render(http.StatusOK, "tokens.html", map[string]interface{}{
+		"tokens":   tokens,
+		"newToken": newToken,
+		"Footer":   MakeFooter(),
+	})
+}
+
+func TokensGet(c echo.Context) error {
+	return renderTokens(c, "")
+}
+
+func TokensPost(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	name := c.FormValue("name")
+
+	form, err := c.FormParams()
+	if err != nil {
+		return c.String(http.StatusBadRequest, "invalid form")
+	}
+	scopes, err := parseScopes(form["scope"])
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+
+	_, plaintext, err := users.CreateToken(database.Get(), userID, name, scopes)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	return renderTokens(c, plaintext)
+}
+
+func TokenRevokePost(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+
+	err := database.Get().Where("id = ? AND user_id = ?", id, userID).Delete(&users.Token{}).Error
+	if err != nil {
+		log.Errorln("couldn't revoke token", id, err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/tokens")
+}
+
+// GET /api/v1/tokens
+func APITokensGet(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	tokens := []users.Token{}
+	err := database.Get().Where("user_id = ?", userID).Order("id DESC").Find(&tokens).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+type apiTokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// POST /api/v1/tokens
+func APITokensPost(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	var req apiTokenRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	scopes, err := parseScopes(req.Scopes)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	token, plaintext, err := users.CreateToken(database.Get(), userID, req.Name, scopes)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"token":  plaintext,
+		"record": token,
+	})
+}
+
+// DELETE /api/v1/tokens/:id
+func APITokenDelete(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+	result := database.Get().Where("id = ? AND user_id = ?", id, userID).Delete(&users.Token{})
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	}
+	if result.RowsAffected == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "no such token")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
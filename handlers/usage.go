@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"ytdlp-site/database"
+	"ytdlp-site/quota"
+	"ytdlp-site/users"
+)
+
+// HumanSize formats a size in bytes with binary units
+func HumanSize(bytes int64) string {
+	const (
+		KiB = 1024
+		MiB = 1024 * KiB
+		GiB = 1024 * MiB
+	)
+
+	if bytes >= GiB {
+		return fmt.Sprintf("%.1f GiB", float64(bytes)/float64(GiB))
+	} else if bytes >= MiB {
+		return fmt.Sprintf("%.1f MiB", float64(bytes)/float64(MiB))
+	} else if bytes >= KiB {
+		return fmt.Sprintf("%.1f KiB", float64(bytes)/float64(KiB))
+	}
+	return fmt.Sprintf("%d bytes", bytes)
+}
+
+type usageRow struct {
+	quota.OriginalUsage
+	Video, Audio, Clips, Total string
+}
+
+func UsageGet(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+
+	var user users.User
+	if err := database.Get().First(&user, userID).Error; err != nil {
+		return c.String(http.StatusInternalServerError, "Unable to retrieve user")
+	}
+	usage, err := quota.GetUsage(userID)
+	if err != nil {
+		log.Errorln("couldn't compute usage for user", userID, err)
+		return c.String(http.StatusInternalServerError, "Unable to compute usage")
+	}
+
+	var rows []usageRow
+	for _, ou := range usage.Originals {
+		if ou.Unprocessed {
+			continue
+		}
+		rows = append(rows, usageRow{
+			OriginalUsage: ou,
+			Video:         HumanSize(ou.VideoBytes),
+			Audio:         HumanSize(ou.AudioBytes),
+			Clips:         HumanSize(ou.ClipBytes),
+			Total:         HumanSize(ou.Bytes),
+		})
+	}
+
+	// percentages for progress bars, 0 if unlimited
+	var bytesPct, itemsPct int
+	if user.QuotaBytes > 0 {
+		bytesPct = int(min(100, 100*usage.Bytes/user.QuotaBytes))
+	}
+	if user.QuotaItems > 0 {
+		itemsPct = int(min(100, 100*usage.Items/int64(user.QuotaItems)))
+	}
+
+	return c.Render(http.StatusOK, "usage.html", map[string]interface{}{
+		"usage":      usage,
+		"rows":       rows,
+		"user":       user,
+		"used":       HumanSize(usage.Bytes),
+		"videos":     HumanSize(usage.VideoBytes),
+		"audios":     HumanSize(usage.AudioBytes),
+		"clips":      HumanSize(usage.ClipBytes),
+		"subtitles":  HumanSize(usage.SubtitleBytes),
+		"quotaBytes": HumanSize(user.QuotaBytes),
+		"bytesPct":   bytesPct,
+		"itemsPct":   itemsPct,
+		"Footer":     MakeFooter(),
+	})
+}
+
+// GET /api/v1/usage
+func APIUsageGet(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+
+	var user users.User
+	if err := database.Get().First(&user, userID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	usage, err := quota.GetUsage(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"usage":       usage,
+		"quota_bytes": user.QuotaBytes,
+		"quota_items": user.QuotaItems,
+	})
+}
@@ -10,10 +10,7 @@ import (
 
 func VideosEvents(c echo.Context) error {
 
-	user, err := GetUser(c)
-	if err != nil {
-		return err
-	}
+	userID := c.Get("user_id").(uint)
 
 	req := c.Request()
 	res := c.Response()
@@ -26,16 +23,15 @@ func VideosEvents(c echo.Context) error {
 	// Create a channel to signal client disconnect
 	done := req.Context().Done()
 
-	q := originals.Subscribe(user.Id)
-	defer originals.Unsubscribe(user.Id, q)
+	q := originals.Subscribe(userID)
+	defer originals.Unsubscribe(userID, q)
 
 	// Send SSE messages
 	for {
 		select {
 		case <-done:
 			return nil
-		default:
-			event := <-q.Ch
+		case event := <-q.Ch:
 
 			jsonData, err := json.Marshal(event)
 			if err != nil {
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"ytdlp-site/authz"
+	"ytdlp-site/config"
+	"ytdlp-site/ffmpeg"
+	"ytdlp-site/media"
+	"ytdlp-site/transcodes"
+)
+
+const (
+	hlsMaster         = "master.m3u8"
+	hlsSegmentSeconds = 6
+)
+
+// video codecs that can be packaged into fMP4 HLS segments without re-encoding
+var hlsCodecs = map[string]bool{"h264": true, "hevc": true, "av1": true}
+
+var errNoHLSVariants = errors.New("no transcoded h264, hevc or av1 videos to package")
+
+// the profile_idc and constraint flags of H.264 profiles, as in avc1.PPCCLL
+var avcProfiles = map[string]string{
+	"Constrained Baseline":  "42e0",
+	"Baseline":              "4200",
+	"Main":                  "4d40",
+	"High":                  "6400",
+	"High 10":               "6e00",
+	"High 4:2:2":            "7a00",
+	"High 4:4:4 Predictive": "f400",
+}
+
+// the profile and compatibility flags of HEVC profiles, as in hvc1.P.C.TLL.B0
+var hevcProfiles = map[string]string{"Main": "1.6", "Main 10": "2.4"}
+
+// seq_profile of AV1 profiles, as in av01.P.LLT.DD
+var av1Profiles = map[string]int{"Main": 0, "High": 1, "Professional": 2}
+
+// the RFC 6381 name of a stream's codec, for the CODECS attribute of a variant.
+// HEVC and AV1 are assumed to use the main tier.
+func codecString(s FFProbeStream) (string, error) {
+	switch s.CodecName {
+	case "h264":
+		if p, ok := avcProfiles[s.Profile]; ok && s.Level > 0 {
+			return fmt.Sprintf("avc1.%s%02x", p, s.Level), nil
+		}
+	case "hevc":
+		if p, ok := hevcProfiles[s.Profile]; ok && s.Level > 0 {
+			return fmt.Sprintf("hvc1.%s.L%d.B0", p, s.Level), nil
+		}
+	case "av1":
+		if p, ok := av1Profiles[s.Profile]; ok && s.Level >= 0 {
+			depth := 8
+			pixFmt := strings.TrimSuffix(strings.TrimSuffix(s.PixFmt, "le"), "be")
+			if strings.HasSuffix(pixFmt, "10") {
+				depth = 10
+			} else if strings.HasSuffix(pixFmt, "12") {
+				depth = 12
+			}
+			return fmt.Sprintf("av01.%d.%02dM.%02d", p, s.Level, depth), nil
+		}
+	case "aac":
+		switch s.Profile {
+		case "HE-AAC":
+			return "mp4a.40.5", nil
+		case "HE-AACv2":
+			return "mp4a.40.29", nil
+		}
+		return "mp4a.40.2", nil
+	case "mp3":
+		return "mp4a.40.34", nil
+	case "opus":
+		return "opus", nil
+	case "flac":
+		return "fLaC", nil
+	case "ac3":
+		return "ac-3", nil
+	case "eac3":
+		return "ec-3", nil
+	}
+	return "", fmt.Errorf("can't name %s codec, profile %q level %d", s.CodecName, s.Profile, s.Level)
+}
+
+// the CODECS attribute of a variant made from a video file
+func hlsCodecsAttribute(path string) (string, error) {
+	video, audio, err := getStreams(path)
+	if err != nil {
+		return "", err
+	}
+	if video == nil {
+		return "", errors.New("no video stream")
+	}
+	codecs, err := codecString(*video)
+	if err != nil {
+		return "", err
+	}
+	if audio != nil {
+		audioCodec, err := codecString(*audio)
+		if err != nil {
+			return "", err
+		}
+		codecs += "," + audioCodec
+	}
+	return codecs, nil
+}
+
+// serializes HLS builds, which only copy streams and are quick
+var hlsMu sync.Mutex
+
+// the transcoded videos of an original that can be HLS variants, smallest first
+func hlsVariants(originalID uint) []media.Video {
+	var videos []media.Video
+	db.Where("original_id = ? AND source = ?", originalID, "transcode").
+		Order("height ASC").Find(&videos)
+
+	var variants []media.Video
+	for _, v := range videos {
+		if hlsCodecs[v.Codec] {
+			variants = append(variants, v)
+		}
+	}
+	return variants
+}
+
+// peak and average bits per second of the segments of a media playlist
+func hlsBandwidth(playlist string) (peak, average int64, err error) {
+	f, err := os.Open(playlist)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var total int64
+	var totalSecs, duration float64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if after, ok := strings.CutPrefix(line, "#EXTINF:"); ok {
+			if i := strings.IndexByte(after, ','); i >= 0 {
+				after = after[:i]
+			}
+			duration, _ = strconv.ParseFloat(after, 64)
+		} else if line != "" && !strings.HasPrefix(line, "#") && duration > 0 {
+			info, err := os.Stat(filepath.Join(filepath.Dir(playlist), line))
+			if err != nil {
+				return 0, 0, err
+			}
+			peak = max(peak, int64(float64(info.Size()*8)/duration))
+			total += info.Size()
+			totalSecs += duration
+			duration = 0
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+	if totalSecs > 0 {
+		average = int64(float64(total*8) / totalSecs)
+	}
+	return peak, average, nil
+}
+
+// package an original's transcoded videos as an HLS ladder, replacing any previous package
+func buildHLS(originalID uint) error {
+	hlsMu.Lock()
+	defer hlsMu.Unlock()
+
+	variants := hlsVariants(originalID)
+	if len(variants) == 0 {
+		return errNoHLSVariants
+	}
+	deleteHLS(originalID)
+
+	dirname := "hls-" + uuid.Must(uuid.NewV7()).String()
+	dir := filepath.Join(config.GetDataDir(), dirname)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	hls := media.HLS{OriginalID: originalID, Dirname: dirname, Status: media.Transcoding}
+	if err := db.Create(&hls).Error; err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	fail := func(err error) error {
+		log.Errorln("couldn't build HLS for original", originalID, err)
+		os.RemoveAll(dir)
+		db.Model(&hls).Updates(map[string]interface{}{"status": media.Failed, "size": 0})
+		return err
+	}
+
+	master := []string{"#EXTM3U", "#EXT-X-VERSION:7", "#EXT-X-INDEPENDENT-SEGMENTS"}
+	packaged := 0
+	for _, v := range variants {
+		src := filepath.Join(config.GetDataDir(), v.Filename)
+		// players pick the variants they can decode by their codecs
+		codecs, err := hlsCodecsAttribute(src)
+		if err != nil {
+			log.Warnln("leaving video", v.ID, "out of HLS for original", originalID, err)
+			continue
+		}
+
+		name := fmt.Sprintf("v%d", packaged)
+		if err := os.Mkdir(filepath.Join(dir, name), 0700); err != nil {
+			return fail(err)
+		}
+		playlist := filepath.Join(dir, name, "index.m3u8")
+		_, stderr, err := ffmpeg.Ffmpeg("-i", src,
+			"-map", "0:v:0", "-map", "0:a:0?", "-c", "copy",
+			"-f", "hls", "-hls_time", fmt.Sprint(hlsSegmentSeconds), "-hls_playlist_type", "vod",
+			"-hls_segment_type", "fmp4", "-hls_fmp4_init_filename", "init.mp4",
+			"-hls_segment_filename", filepath.Join(dir, name, "seg%05d.m4s"),
+			playlist)
+		if err != nil {
+			return fail(fmt.Errorf("package video %d: %w: %s", v.ID, err, stderr))
+		}
+
+		peak, average, err := hlsBandwidth(playlist)
+		if err != nil {
+			return fail(err)
+		}
+		inf := fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,AVERAGE-BANDWIDTH=%d,CODECS=\"%s\",RESOLUTION=%dx%d",
+			peak, average, codecs, v.Width, v.Height)
+		if v.FPS > 0 {
+			inf += fmt.Sprintf(",FRAME-RATE=%.3f", v.FPS)
+		}
+		master = append(master, inf, name+"/index.m3u8")
+		packaged++
+	}
+	if packaged == 0 {
+		return fail(errNoHLSVariants)
+	}
+
+	err := os.WriteFile(filepath.Join(dir, hlsMaster), []byte(strings.Join(master, "\n")+"\n"), 0600)
+	if err != nil {
+		return fail(err)
+	}
+	db.Model(&hls).Updates(map[string]interface{}{"status": media.Completed, "size": pathSize(dir)})
+	log.Infof("built HLS for original %d with %d variants in %s", originalID, packaged, dirname)
+	return nil
+}
+
+// build the HLS package once an original's video transcodes have finished, if its profile asks for one
+func maybeBuildHLS(originalID uint) {
+	// failed transcodes stay around, but won't produce a variant
+	var count int64
+	db.Model(&transcodes.Transcode{}).
+		Where("original_id = ? AND dst_kind = ? AND status IN ?", originalID, "video", []string{"pending", "running"}).
+		Count(&count)
+	if count > 0 {
+		return
+	}
+	profile, err := originalProfile(originalID)
+	if err != nil || !profile.HLS {
+		return
+	}
+	if err := buildHLS(originalID); err != nil && !errors.Is(err, errNoHLSVariants) {
+		log.Errorln("HLS for original", originalID, err)
+	}
+}
+
+// remove an original's HLS packages
+func deleteHLS(originalID uint) {
+	var packages []media.HLS
+	db.Where("original_id = ?", originalID).Find(&packages)
+	for _, hls := range packages {
+		dir := filepath.Join(config.GetDataDir(), hls.Dirname)
+		log.Debugln("remove", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			log.Errorln("error removing", dir, err)
+		}
+	}
+	db.Delete(&media.HLS{}, "original_id = ?", originalID)
+}
+
+func buildHLSHandler(c echo.Context) error {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	orig, err := authz.Original(c.Get("user_id").(uint), uint(id))
+	if err != nil {
+		return c.String(http.StatusNotFound, "no such original")
+	}
+	go func() {
+		if err := buildHLS(orig.ID); err != nil {
+			log.Errorln("HLS for original", orig.ID, err)
+		}
+	}()
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/video/%d", orig.ID))
+}
+
+func deleteHLSHandler(c echo.Context) error {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	orig, err := authz.Original(c.Get("user_id").(uint), uint(id))
+	if err != nil {
+		return c.String(http.StatusNotFound, "no such original")
+	}
+	hlsMu.Lock()
+	deleteHLS(orig.ID)
+	hlsMu.Unlock()
+	return c.Redirect(http.StatusSeeOther, fmt.Sprintf("/video/%d", orig.ID))
+}
+
+// GET /hls/:id/*, the playlists and segments of an HLS package
+func hlsFileHandler(c echo.Context) error {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	hls, err := authz.HLS(c.Get("user_id").(uint), uint(id))
+	if err != nil || hls.Status != media.Completed {
+		return echo.ErrNotFound
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+c.Param("*")), "/")
+	switch path.Ext(name) {
+	case ".m3u8":
+		c.Response().Header().Set(echo.HeaderContentType, "application/vnd.apple.mpegurl")
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-cache")
+	case ".m4s":
+		c.Response().Header().Set(echo.HeaderContentType, "video/iso.segment")
+	case ".mp4":
+		c.Response().Header().Set(echo.HeaderContentType, "video/mp4")
+	default:
+		return echo.ErrNotFound
+	}
+	return c.File(filepath.Join(config.GetDataDir(), hls.Dirname, filepath.FromSlash(name)))
+}
+
+// POST /api/v1/originals/:id/hls
+func apiBuildHLSHandler(c echo.Context) error {
+	orig, err := apiOriginal(c)
+	if err != nil {
+		return err
+	}
+	if len(hlsVariants(orig.ID)) == 0 {
+		return echo.NewHTTPError(http.StatusConflict, errNoHLSVariants.Error())
+	}
+	go func() {
+		if err := buildHLS(orig.ID); err != nil {
+			log.Errorln("HLS for original", orig.ID, err)
+		}
+	}()
+	return c.NoContent(http.StatusAccepted)
+}
+
+// GET /api/v1/originals/:id/hls
+func apiHLSHandler(c echo.Context) error {
+	orig, err := apiOriginal(c)
+	if err != nil {
+		return err
+	}
+	var packages []media.HLS
+	if err := db.Where("original_id = ?", orig.ID).Order("id ASC").Find(&packages).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	type hlsResponse struct {
+		media.HLS
+		URL string `json:"url,omitempty"`
+	}
+	resp := []hlsResponse{}
+	for _, hls := range packages {
+		r := hlsResponse{HLS: hls}
+		if hls.Status == media.Completed {
+			r.URL = fmt.Sprintf("/hls/%d/%s", hls.ID, hlsMaster)
+		}
+		resp = append(resp, r)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
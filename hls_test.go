@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ytdlp-site/media"
+	"ytdlp-site/originals"
+	"ytdlp-site/profiles"
+	"ytdlp-site/transcodes"
+)
+
+func TestHLSBandwidth(t *testing.T) {
+	tests := []struct {
+		name     string
+		playlist string
+		segments map[string]int // segment sizes in bytes
+		peak     int64
+		average  int64
+		err      bool
+	}{
+		{"even segments",
+			"#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:4.000000,\nseg0.m4s\n#EXTINF:4.000000,\nseg1.m4s\n#EXT-X-ENDLIST\n",
+			map[string]int{"seg0.m4s": 1000, "seg1.m4s": 1000}, 2000, 2000, false},
+		{"short last segment",
+			"#EXTM3U\n#EXTINF:4,\nseg0.m4s\n#EXTINF:1.0,\nseg1.m4s\n",
+			map[string]int{"seg0.m4s": 2000, "seg1.m4s": 1000}, 8000, 4800, false},
+		{"titles and blank lines",
+			"#EXTM3U\n\n#EXTINF:2.5,intro\n  seg0.m4s  \n",
+			map[string]int{"seg0.m4s": 500}, 1600, 1600, false},
+		{"no segments", "#EXTM3U\n#EXT-X-ENDLIST\n", nil, 0, 0, false},
+		{"missing segment", "#EXTM3U\n#EXTINF:4,\nseg0.m4s\n", nil, 0, 0, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			playlist := filepath.Join(dir, "index.m3u8")
+			if err := os.WriteFile(playlist, []byte(tc.playlist), 0600); err != nil {
+				t.Fatal(err)
+			}
+			for name, size := range tc.segments {
+				if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			peak, average, err := hlsBandwidth(playlist)
+			if (err != nil) != tc.err {
+				t.Fatalf("err = %v, want error %t", err, tc.err)
+			}
+			if peak != tc.peak || average != tc.average {
+				t.Errorf("got peak %d average %d, want %d and %d", peak, average, tc.peak, tc.average)
+			}
+		})
+	}
+
+	if _, _, err := hlsBandwidth(filepath.Join(t.TempDir(), "missing.m3u8")); err == nil {
+		t.Error("no error for a missing playlist")
+	}
+}
+
+func TestCodecString(t *testing.T) {
+	tests := []struct {
+		stream FFProbeStream
+		want   string // "" for an error
+	}{
+		{FFProbeStream{CodecName: "h264", Profile: "High", Level: 31}, "avc1.64001f"},
+		{FFProbeStream{CodecName: "h264", Profile: "Constrained Baseline", Level: 30}, "avc1.42e01e"},
+		{FFProbeStream{CodecName: "h264", Profile: "Main", Level: 40}, "avc1.4d4028"},
+		{FFProbeStream{CodecName: "h264", Profile: "High", Level: -99}, ""},
+		{FFProbeStream{CodecName: "h264", Profile: "Extended", Level: 30}, ""},
+		{FFProbeStream{CodecName: "hevc", Profile: "Main", Level: 93}, "hvc1.1.6.L93.B0"},
+		{FFProbeStream{CodecName: "hevc", Profile: "Main 10", Level: 120}, "hvc1.2.4.L120.B0"},
+		{FFProbeStream{CodecName: "hevc", Profile: "Rext", Level: 120}, ""},
+		{FFProbeStream{CodecName: "av1", Profile: "Main", Level: 8, PixFmt: "yuv420p"}, "av01.0.08M.08"},
+		{FFProbeStream{CodecName: "av1", Profile: "Main", Level: 12, PixFmt: "yuv420p10le"}, "av01.0.12M.10"},
+		{FFProbeStream{CodecName: "av1", Profile: "Main", Level: 0, PixFmt: "yuv410p"}, "av01.0.00M.08"},
+		{FFProbeStream{CodecName: "av1", Profile: "Main", Level: -99}, ""},
+		{FFProbeStream{CodecName: "aac", Profile: "LC"}, "mp4a.40.2"},
+		{FFProbeStream{CodecName: "aac", Profile: "HE-AAC"}, "mp4a.40.5"},
+		{FFProbeStream{CodecName: "mp3"}, "mp4a.40.34"},
+		{FFProbeStream{CodecName: "opus"}, "opus"},
+		{FFProbeStream{CodecName: "flac"}, "fLaC"},
+		{FFProbeStream{CodecName: "vp9", Profile: "Profile 0", Level: -99}, ""},
+	}
+	for _, tc := range tests {
+		got, err := codecString(tc.stream)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("codecString(%+v) = %s, want an error", tc.stream, got)
+			}
+		} else if err != nil || got != tc.want {
+			t.Errorf("codecString(%+v) = %s, %v; want %s", tc.stream, got, err, tc.want)
+		}
+	}
+}
+
+func TestMaybeBuildHLSWaitsOnlyForActiveTranscodes(t *testing.T) {
+	setupTestServer(t)
+	alice := createTestUser(t, "alice")
+	if err := profiles.EnsureDefault(db); err != nil {
+		t.Fatal(err)
+	}
+	def, err := profiles.Resolve(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := profiles.SetHLS(db, def.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	orig := originals.Original{UserID: alice.user.ID, Status: originals.StatusTranscoding}
+	db.Create(&orig)
+	db.Create(&media.Video{OriginalID: orig.ID, Source: "transcode",
+		VideoFile: media.VideoFile{MediaFile: media.MediaFile{Codec: "h264", Filename: "done.mp4"}, Height: 540}})
+
+	// packaging isn't attempted while a transcode may still add a variant;
+	// afterwards the bogus video makes it fail, but leaves a record
+	attempted := func() bool {
+		var n int64
+		db.Model(&media.HLS{}).Where("original_id = ?", orig.ID).Count(&n)
+		return n > 0
+	}
+	for _, status := range []string{"pending", "running"} {
+		trans := transcodes.Transcode{OriginalID: orig.ID, DstKind: "video", Status: status}
+		db.Create(&trans)
+		maybeBuildHLS(orig.ID)
+		if attempted() {
+			t.Fatalf("HLS built with a %s transcode", status)
+		}
+		db.Model(&trans).Update("status", "failed")
+	}
+	maybeBuildHLS(orig.ID)
+	if !attempted() {
+		t.Error("HLS not built with only failed transcodes left")
+	}
+}
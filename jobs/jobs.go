@@ -0,0 +1,95 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+)
+
+type Kind string
+
+const (
+	Download  Kind = "download"  // keyed by Original.ID
+	Transcode Kind = "transcode" // keyed by Transcode.ID
+)
+
+type key struct {
+	kind Kind
+	id   uint
+}
+
+type job struct {
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+var running = map[key]*job{}
+var mu sync.Mutex
+
+// Register records the cancel func for the current step of a running job.
+// If the job was already cancelled, cancel is called immediately
+func Register(kind Kind, id uint, cancel context.CancelFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	k := key{kind, id}
+	j, ok := running[k]
+	if !ok {
+		running[k] = &job{cancel: cancel}
+		return
+	}
+	j.cancel = cancel
+	if j.cancelled {
+		cancel()
+	}
+}
+
+// Unregister forgets a job once it has finished
+func Unregister(kind Kind, id uint) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(running, key{kind, id})
+}
+
+// Finish forgets a job that can no longer be cancelled, and reports whether
+// it was cancelled before that
+func Finish(kind Kind, id uint) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	k := key{kind, id}
+	j, ok := running[k]
+	delete(running, k)
+	return ok && j.cancelled
+}
+
+// Cancel cancels a running job. Returns false if there is no such job
+func Cancel(kind Kind, id uint) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	j, ok := running[key{kind, id}]
+	if !ok {
+		return false
+	}
+	j.cancelled = true
+	j.cancel()
+	return true
+}
+
+// Cancelled reports whether a running job has been cancelled
+func Cancelled(kind Kind, id uint) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	j, ok := running[key{kind, id}]
+	return ok && j.cancelled
+}
+
+// Running reports whether a job is running
+func Running(kind Kind, id uint) bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	_, ok := running[key{kind, id}]
+	return ok
+}
@@ -6,7 +6,6 @@ import (
 	"io"
 	golog "log"
 	"os"
-	"path/filepath"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -17,11 +16,15 @@ import (
 
 	"ytdlp-site/config"
 	"ytdlp-site/database"
+	"ytdlp-site/diskspace"
+	"ytdlp-site/downloads"
 	"ytdlp-site/ffmpeg"
 	"ytdlp-site/handlers"
 	"ytdlp-site/media"
 	"ytdlp-site/originals"
 	"ytdlp-site/playlists"
+	"ytdlp-site/profiles"
+	"ytdlp-site/search"
 	"ytdlp-site/transcodes"
 	"ytdlp-site/users"
 	"ytdlp-site/ytdlp"
@@ -40,14 +43,28 @@ func ensureAdminAccount(db *gorm.DB) error {
 			return err
 		}
 
-		err = users.Create(db, "admin", password)
+		_, err = users.CreateUser(db, "admin", password, true)
 		if err != nil {
 			return err
 		}
 	}
+
+	// databases from before roles existed have no admin
+	var admins int64
+	db.Model(&users.User{}).Where("is_admin = ?", true).Count(&admins)
+	if admins == 0 {
+		return db.Model(&users.User{}).Where("username = ?", "admin").Update("is_admin", true).Error
+	}
 	return nil
 }
 
+// every model with a table
+var models = []interface{}{&originals.Original{}, &originals.Chapter{}, &playlists.Playlist{},
+	&media.Video{}, &media.Audio{}, &media.VideoClip{}, &media.AudioClip{}, &media.Subtitle{}, &media.HLS{},
+	&users.User{}, &TempURL{}, &transcodes.Transcode{},
+	&downloads.Download{}, &users.Token{}, &users.Invite{}, &users.Session{}, &users.RecoveryCode{},
+	&profiles.Profile{}, &profiles.Rendition{}}
+
 func main() {
 
 	initLogger()
@@ -56,6 +73,7 @@ func main() {
 	log.Infof("BuildDate: %s", config.GetBuildDate())
 
 	ffmpeg.Init(log)
+	diskspace.Init(log)
 	handlers.Init(log)
 	ytdlp.Init(log)
 	originals.Init(log)
@@ -79,7 +97,7 @@ func main() {
 	}
 
 	// Initialize database
-	dbPath := filepath.Join(config.GetConfigDir(), "videos.db")
+	dbPath := config.GetDatabasePath()
 	db, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{
 		Logger: gormLogger,
 	})
@@ -95,12 +113,18 @@ func main() {
 	sqlDB.SetMaxOpenConns(1)
 
 	// Migrate the schema
-	db.AutoMigrate(&originals.Original{}, &playlists.Playlist{},
-		&media.Video{}, &media.Audio{}, &media.VideoClip{},
-		&users.User{}, &TempURL{}, &transcodes.Transcode{})
+	if err := db.AutoMigrate(models...); err != nil {
+		log.Errorln("couldn't migrate the database", err)
+	}
 
 	database.Init(db, log)
+	if err := media.RegisterTypes(); err != nil {
+		log.Errorln("couldn't register media types", err)
+	}
 	defer database.Fini()
+	if !search.Setup() {
+		log.Warnln("SQLite was built without FTS5, search falls back to matching titles, artists and descriptions")
+	}
 	err = handlers.Init(log)
 	if err != nil {
 		panic(fmt.Sprintf("%v", err))
@@ -115,13 +139,45 @@ func main() {
 		panic(fmt.Sprintf("failed to create admin user: %v", err))
 	}
 
+	if err := profiles.EnsureDefault(db); err != nil {
+		panic(fmt.Sprintf("failed to create default transcode profile: %v", err))
+	}
+
 	// Initialize Echo
 	e := echo.New()
+	e.IPExtractor = handlers.IPExtractor()
 
 	// Middleware
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 
+	addRoutes(e)
+
+	// tidy up the transcodes database
+	log.Debug("tidy transcodes database...")
+	cleanupTranscodes()
+	go backfillMediaInfo()
+	go func() {
+		if n, err := search.Backfill(); err != nil {
+			log.Errorln("couldn't build search index", err)
+		} else if n > 0 {
+			log.Infoln("indexed", n, "originals for search")
+		}
+	}()
+
+	// recover interrupted downloads and start the download workers
+	log.Debug("tidy downloads database...")
+	cleanupDownloads()
+	go diskspace.Monitor(30*time.Second, wakeDownloadWorkers)
+	startDownloadWorkers()
+	go PeriodicPlaylistRefresh()
+
+	// Start server
+	e.Logger.Fatal(e.Start(":8080"))
+}
+
+// set up the renderer and every route
+func addRoutes(e *echo.Echo) {
 	// Templates
 	t := &Template{
 		templates: template.Must(template.ParseGlob("templates/*.html")),
@@ -132,42 +188,86 @@ func main() {
 	e.GET("/", homeHandler)
 	e.GET("/login", handlers.LoginGet)
 	e.POST("/login", handlers.LoginPost)
-	// e.GET("/register", registerHandler)
-	// e.POST("/register", registerPostHandler)
+	e.GET("/login/totp", handlers.LoginTOTPGet)
+	e.POST("/login/totp", handlers.LoginTOTPPost)
+	e.GET("/register", registerHandler)
+	e.POST("/register", registerPostHandler)
 	e.GET("/logout", handlers.LogoutGet)
 	e.GET("/download", downloadHandler, handlers.AuthMiddleware)
-	e.POST("/download", downloadPostHandler, handlers.AuthMiddleware)
+	e.POST("/download", downloadPostHandler, handlers.SubmitAuthMiddleware)
 	e.GET("/videos", videosHandler, handlers.AuthMiddleware)
+	e.GET("/search", searchHandler, handlers.AuthMiddleware)
 	e.GET("/video/:id", videoHandler, handlers.AuthMiddleware)
 	e.POST("/video/:id/restart", videoRestartHandler, handlers.AuthMiddleware)
+	e.POST("/video/:id/cancel", videoCancelHandler, handlers.AuthMiddleware)
 	e.POST("/video/:id/delete", deleteOriginalHandler, handlers.AuthMiddleware)
 	e.GET("/temp/:token", tempHandler)
+	e.GET("/feed/:key/audio", userFeedHandler)
+	e.GET("/feed/:key/audio/:id/:name", feedAudioHandler)
+	e.GET("/feed/:key/playlists/:id", playlistFeedHandler)
 	e.POST("/video/:id/process", processHandler, handlers.AuthMiddleware)
+	e.POST("/video/:id/hls", buildHLSHandler, handlers.AuthMiddleware)
+	e.POST("/video/:id/hls/delete", deleteHLSHandler, handlers.AuthMiddleware)
+	e.POST("/video/:id/chapters/split", splitChaptersHandler, handlers.AuthMiddleware)
+	e.GET("/hls/:id/*", hlsFileHandler, handlers.AuthMiddleware)
 	e.POST("/video/:id/toggle_watched", handlers.ToggleWatched, handlers.AuthMiddleware)
 	e.POST("/delete_video/:id", deleteVideoHandler, handlers.AuthMiddleware)
 	e.POST("/delete_audio/:id", deleteAudioHandler, handlers.AuthMiddleware)
+	e.POST("/clip", handlers.ClipPost, handlers.AuthMiddleware)
+	e.POST("/delete_video_clip/:id", handlers.VideoClipDelete, handlers.AuthMiddleware)
+	e.POST("/delete_audio_clip/:id", handlers.AudioClipDelete, handlers.AuthMiddleware)
 	e.POST("/transcode_to_video/:id", transcodeToVideoHandler, handlers.AuthMiddleware)
 	e.POST("/transcode_to_audio/:id", transcodeToAudioHandler, handlers.AuthMiddleware)
-	e.GET("/status", handlers.StatusGet, handlers.AuthMiddleware)
+	e.POST("/transcode/:id/cancel", transcodeCancelHandler, handlers.AuthMiddleware)
+	e.GET("/status", handlers.StatusGet, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.GET("/users", usersHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.GET("/reconcile", reconcileHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.POST("/reconcile", reconcilePostHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.POST("/users", usersPostHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.POST("/users/:id/disable", userDisableHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.POST("/users/:id/password", userPasswordHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.POST("/users/:id/quota", userQuotaHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.POST("/users/:id/delete", userDeleteHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.POST("/invites", invitesPostHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.POST("/invites/:id/delete", inviteDeleteHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.GET("/profiles", profilesHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.POST("/profiles", profilesPostHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.POST("/profiles/:id/default", profileDefaultHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.POST("/profiles/:id/hls", profileHLSHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.POST("/profiles/:id/delete", profileDeleteHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.POST("/profiles/:id/renditions", renditionPostHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
+	e.POST("/profiles/:id/renditions/:rid/delete", renditionDeleteHandler, handlers.AuthMiddleware, handlers.AdminMiddleware)
 	e.GET("/videos/events", handlers.VideosEvents, handlers.AuthMiddleware)
+	e.GET("/account", handlers.AccountGet, handlers.AuthMiddleware)
+	e.POST("/account/password", handlers.AccountPasswordPost, handlers.AuthMiddleware)
+	e.POST("/account/profile", handlers.AccountProfilePost, handlers.AuthMiddleware)
+	e.POST("/account/feed/reset", handlers.AccountFeedResetPost, handlers.AuthMiddleware)
+	e.POST("/account/totp/setup", handlers.AccountTOTPSetupPost, handlers.AuthMiddleware)
+	e.POST("/account/totp/enable", handlers.AccountTOTPEnablePost, handlers.AuthMiddleware)
+	e.POST("/account/totp/disable", handlers.AccountTOTPDisablePost, handlers.AuthMiddleware)
+	e.POST("/account/totp/recovery", handlers.AccountRecoveryCodesPost, handlers.AuthMiddleware)
+	e.POST("/account/logout_all", handlers.AccountLogoutAllPost, handlers.AuthMiddleware)
+	e.POST("/account/sessions/:id/delete", handlers.AccountSessionDeletePost, handlers.AuthMiddleware)
+	e.GET("/usage", handlers.UsageGet, handlers.AuthMiddleware)
+	e.GET("/tokens", handlers.TokensGet, handlers.AuthMiddleware)
+	e.POST("/tokens", handlers.TokensPost, handlers.AuthMiddleware)
+	e.POST("/tokens/:id/revoke", handlers.TokenRevokePost, handlers.AuthMiddleware)
 
 	e.GET("/p/:id", playlistHandler, handlers.AuthMiddleware)
 	e.POST("/p/:id/delete", deletePlaylistHandler, handlers.AuthMiddleware)
+	e.POST("/p/:id/refresh", refreshPlaylistHandler, handlers.AuthMiddleware)
+	e.POST("/p/:id/schedule", playlistScheduleHandler, handlers.AuthMiddleware)
+	e.POST("/p/:id/retention", playlistRetentionHandler, handlers.AuthMiddleware)
+
+	addAPIRoutes(e)
 
 	dataGroup := e.Group("/data")
-	dataGroup.Use(handlers.AuthMiddleware)
+	dataGroup.Use(handlers.AuthMiddleware, handlers.DataMiddleware)
 	dataGroup.Static("/", config.GetDataDir())
 
 	staticGroup := e.Group("/static")
 	staticGroup.Use(handlers.AuthMiddleware)
 	staticGroup.Static("/", "static")
-
-	// tidy up the transcodes database
-	log.Debug("tidy transcodes database...")
-	cleanupTranscodes()
-
-	// Start server
-	e.Logger.Fatal(e.Start(":8080"))
 }
 
 // Template renderer
@@ -176,5 +276,11 @@ type Template struct {
 }
 
 func (t *Template) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
+	// lets the header show admin-only links
+	if m, ok := data.(map[string]interface{}); ok {
+		m["IsAdmin"] = c.Get("is_admin")
+		m["ForwardAuth"] = handlers.ForwardAuthEnabled()
+		m["LowDiskSpace"] = diskspace.Low()
+	}
 	return t.templates.ExecuteTemplate(w, name, data)
 }
@@ -46,8 +46,37 @@ type Video struct {
 type VideoClip struct {
 	gorm.Model
 	VideoFile
-	OriginalID uint // Original.ID
-	VideoID    uint // Video.ID that this clip was made from
+	OriginalID uint   // Original.ID
+	VideoID    uint   // Video.ID that this clip was made from
+	Title      string // chapter title, if split from a chapter
 	StartMS    uint
 	StopMS     uint
 }
+
+type AudioClip struct {
+	gorm.Model
+	MediaFile
+	OriginalID uint   // Original.ID
+	AudioID    uint   // Audio.ID that this clip was made from
+	Title      string // chapter title, if split from a chapter
+	StartMS    uint
+	StopMS     uint
+}
+
+// a WebVTT subtitle track of an original
+type Subtitle struct {
+	gorm.Model
+	MediaFile
+	OriginalID uint   // Original.ID
+	Lang       string // language code as reported by yt-dlp, e.g. "en" or "pt-BR"
+	Auto       bool   // the source's automatic captions rather than uploaded subtitles
+}
+
+// an HLS package of an original's video renditions
+type HLS struct {
+	gorm.Model
+	OriginalID uint   // Original.ID
+	Dirname    string // directory in the data directory holding the playlists and segments
+	Size       int64  // total size of the directory
+	Status     Status
+}
@@ -0,0 +1,56 @@
+package media
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// MIME types of the containers we download or produce, by extension
+var videoTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+	".mov":  "video/quicktime",
+}
+
+var audioTypes = map[string]string{
+	".m4a":  "audio/mp4",
+	".mp4":  "audio/mp4",
+	".webm": "audio/webm",
+	".opus": "audio/ogg",
+	".ogg":  "audio/ogg",
+	".mp3":  "audio/mpeg",
+	".flac": "audio/flac",
+	".wav":  "audio/wav",
+}
+
+// SubtitleType is the MIME type subtitles are stored as
+const SubtitleType = "text/vtt"
+
+// TypeFor returns the MIME type of a video or audio file from its name,
+// or "" if the extension isn't known
+func TypeFor(filename string, video bool) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if video {
+		return videoTypes[ext]
+	}
+	return audioTypes[ext]
+}
+
+// RegisterTypes adds the media MIME types to the mime package, so files are
+// served with the right Content-Type even where the system doesn't know them
+func RegisterTypes() error {
+	for ext, typ := range audioTypes {
+		if err := mime.AddExtensionType(ext, typ); err != nil {
+			return err
+		}
+	}
+	for ext, typ := range videoTypes { // video wins for .mp4 and .webm
+		if err := mime.AddExtensionType(ext, typ); err != nil {
+			return err
+		}
+	}
+	return mime.AddExtensionType(".vtt", SubtitleType)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 	"ytdlp-site/originals"
+	"ytdlp-site/users"
 
 	"github.com/google/uuid"
 )
@@ -52,6 +53,15 @@ func cleanupExpiredURLs() {
 	}
 }
 
+func cleanupExpiredSessions() {
+	n, err := users.DeleteExpiredSessions(db)
+	if err != nil {
+		log.Errorln("error cleaning up expired sessions:", err)
+	} else {
+		log.Debugf("cleaned up %d expired sessions", n)
+	}
+}
+
 func vacuumDatabase() {
 	if err := db.Exec("VACUUM").Error; err != nil {
 		log.Errorln(err)
@@ -60,10 +70,16 @@ func vacuumDatabase() {
 
 func PeriodicCleanup() {
 	cleanupExpiredURLs()
+	cleanupExpiredSessions()
+	reconcileScheduled()
+	applyRetentionRules()
 	vacuumDatabase()
 	ticker := time.NewTicker(1 * time.Hour)
 	for range ticker.C {
 		cleanupExpiredURLs()
+		cleanupExpiredSessions()
+		reconcileScheduled()
+		applyRetentionRules()
 		vacuumDatabase()
 	}
 }
@@ -0,0 +1,42 @@
+package originals
+
+import (
+	"gorm.io/gorm"
+
+	"ytdlp-site/database"
+)
+
+// a chapter of an original, as reported by yt-dlp
+type Chapter struct {
+	gorm.Model
+	OriginalID uint // Original.ID
+	Index      uint // position among the original's chapters, from 0
+	Title      string
+	StartMS    uint
+	StopMS     uint
+}
+
+// replace the chapters of an original
+func SetChapters(id uint, chapters []Chapter) error {
+	return database.Get().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&Chapter{}, "original_id = ?", id).Error; err != nil {
+			return err
+		}
+		for i := range chapters {
+			chapters[i].ID = 0
+			chapters[i].OriginalID = id
+			chapters[i].Index = uint(i)
+		}
+		if len(chapters) == 0 {
+			return nil
+		}
+		return tx.Create(&chapters).Error
+	})
+}
+
+// the chapters of an original, in order
+func GetChapters(id uint) ([]Chapter, error) {
+	var chapters []Chapter
+	err := database.Get().Where("original_id = ?", id).Order("`index` ASC").Find(&chapters).Error
+	return chapters, err
+}
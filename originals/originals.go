@@ -19,18 +19,28 @@ const (
 	StatusTranscoding       Status = "transcoding"
 	StatusCompleted         Status = "completed"
 	StatusFailed            Status = "failed"
+	StatusCancelled         Status = "cancelled"
+	StatusQuotaExceeded     Status = "quota exceeded"
 )
 
+// Statuses lists every Status, in the order an original goes through them
+var Statuses = []Status{StatusNotStarted, StatusMetadata, StatusDownloading, StatusDownloadCompleted,
+	StatusTranscoding, StatusCompleted, StatusFailed, StatusCancelled, StatusQuotaExceeded}
+
 type Original struct {
 	gorm.Model
-	UserID  uint
-	URL     string
-	Title   string
-	Artist  string
-	Status  Status
-	Audio   bool // video download requested
-	Video   bool // audio download requested
-	Watched bool
+	UserID      uint
+	URL         string
+	Title       string
+	Artist      string
+	Description string
+	Status      Status
+	Audio       bool // video download requested
+	Video       bool // audio download requested
+	Watched     bool
+
+	ProfileID uint   // Profile.ID to transcode with (0 for the user's profile)
+	SubLangs  string // yt-dlp --sub-langs to download subtitles in ("" for none)
 
 	Playlist   bool // part of a playlist
 	PlaylistID uint // Playlist.ID (if part of a playlist)
@@ -51,6 +61,19 @@ func bcast(userId, origId uint, pl VideoEventPayload) {
 	}
 }
 
+// like bcast, but drop the event for any listener that isn't keeping up
+func bcastLossy(userId, origId uint, pl VideoEventPayload) {
+	lMu.Lock()
+	defer lMu.Unlock()
+
+	for _, q := range listeners[userId] {
+		select {
+		case q.Ch <- Event{origId, pl}:
+		default:
+		}
+	}
+}
+
 func SetStatus(id uint, status Status) error {
 	db := database.Get()
 	log.Debugln("original", id, "status -> ", status)
@@ -87,13 +110,59 @@ func SetStatusTranscodingOrCompleted(id uint) error {
 	}
 }
 
+// download progress of an original
+type Progress struct {
+	Bytes   int64   // bytes downloaded
+	Total   int64   // total bytes, 0 if unknown
+	Speed   float64 // bytes / second
+	ETA     int64   // seconds remaining, -1 if unknown
+	Percent float64 // 0-100, -1 if unknown
+}
+
+// progress of a transcode of an original
+type TranscodeProgress struct {
+	ID      uint    // Transcode.ID
+	Percent float64 // 0-100
+	ETA     int64   // seconds remaining, -1 if unknown
+}
+
 type VideoEventPayload struct {
-	Status Status
-	Title  string
+	Status    Status
+	Title     string
+	Progress  *Progress          `json:",omitempty"`
+	Transcode *TranscodeProgress `json:",omitempty"`
 }
 
 func makeVideosPayload(status Status, title string) VideoEventPayload {
-	return VideoEventPayload{status, title}
+	return VideoEventPayload{Status: status, Title: title}
+}
+
+// notify listeners of transcode progress for an original
+func SetTranscodeProgress(id uint, progress TranscodeProgress) error {
+	db := database.Get()
+	var orig Original
+	err := db.Where("id = ?", id).First(&orig).Error
+	if err != nil {
+		return err
+	}
+	pl := makeVideosPayload(orig.Status, orig.Title)
+	pl.Transcode = &progress
+	bcastLossy(orig.UserID, id, pl)
+	return nil
+}
+
+// notify listeners of download progress for an original
+func SetDownloadProgress(id uint, progress Progress) error {
+	db := database.Get()
+	var orig Original
+	err := db.Where("id = ?", id).First(&orig).Error
+	if err != nil {
+		return err
+	}
+	pl := makeVideosPayload(orig.Status, orig.Title)
+	pl.Progress = &progress
+	bcastLossy(orig.UserID, id, pl)
+	return nil
 }
 
 type Event struct {
@@ -109,7 +178,7 @@ type Queue struct {
 func newQueue() *Queue {
 	return &Queue{
 		id: uuid.Must(uuid.NewV7()),
-		Ch: make(chan Event),
+		Ch: make(chan Event, 16),
 	}
 }
 
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"ytdlp-site/authz"
+	"ytdlp-site/originals"
+	"ytdlp-site/playlists"
+)
+
+const (
+	defaultSubscriptionRefreshHours = 24
+	defaultSubscriptionKeepLast     = 10
+)
+
+// only refresh one playlist at a time
+var refreshMu sync.Mutex
+
+// retrieve the entries of a playlist, and create and download
+// an original for any entry that is not already part of the playlist
+func refreshPlaylist(id uint) {
+	refreshMu.Lock()
+	defer refreshMu.Unlock()
+
+	var playlist playlists.Playlist
+	if err := db.First(&playlist, id).Error; err != nil {
+		log.Errorln("no such playlist", id, err)
+		return
+	}
+	firstRefresh := playlist.LastRefresh.IsZero()
+	if firstRefresh {
+		playlists.SetStatus(id, playlists.StatusDownloading)
+	}
+
+	// retrieve playlist metadata
+	pl, err := getYtdlpPlaylist(playlist.URL)
+	if err != nil {
+		log.Errorln("couldn't retrieve playlist", id, err)
+		if firstRefresh {
+			playlists.SetStatus(id, playlists.StatusFailed)
+		}
+		return
+	}
+	err = db.Model(&playlists.Playlist{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"title":        pl.Title,
+		"last_refresh": time.Now(),
+	}).Error
+	if err != nil {
+		playlists.SetStatus(id, playlists.StatusFailed)
+		return
+	}
+
+	// URLs already in this playlist.
+	// Include deleted originals, so entries the user removed are not downloaded again
+	var existing []string
+	err = db.Unscoped().Model(&originals.Original{}).
+		Where("playlist = ? AND playlist_id = ?", true, id).
+		Pluck("url", &existing).Error
+	if err != nil {
+		log.Errorln("couldn't retrieve existing entries for playlist", id, err)
+		return
+	}
+	seen := map[string]bool{}
+	for _, url := range existing {
+		seen[url] = true
+	}
+
+	entries := pl.Entries
+	if playlist.Subscription {
+		entries = subscriptionEntries(playlist, entries)
+	}
+
+	for _, entry := range entries {
+		if seen[entry.URL] {
+			continue
+		}
+		seen[entry.URL] = true
+
+		log.Debugln("new entry", entry.URL, "for playlist", id)
+		original := originals.Original{
+			UserID:     playlist.UserID,
+			Title:      entry.Title,
+			URL:        entry.URL,
+			Status:     originals.StatusNotStarted,
+			Video:      playlist.Video,
+			Audio:      playlist.Audio,
+			Playlist:   true,
+			PlaylistID: id,
+			ProfileID:  playlist.ProfileID,
+			SubLangs:   playlist.SubLangs,
+		}
+		err = db.Create(&original).Error
+		if err != nil {
+			playlists.SetStatus(id, playlists.StatusFailed)
+			return
+		}
+		if err := newDownload(original.ID); err != nil {
+			log.Errorln("couldn't queue download for original", original.ID, err)
+		}
+	}
+
+	if err := playlists.UpdateStatus(id); err != nil {
+		log.Errorln("couldn't update status for playlist", id, err)
+	}
+}
+
+// Channels list their uploads newest first, and may contain nested playlists (e.g. tabs).
+// Only consider the newest videos that the retention rules would keep, and return them
+// oldest first so that newer uploads get newer originals.
+func subscriptionEntries(playlist playlists.Playlist, entries []PlaylistEntry) []PlaylistEntry {
+	var videos []PlaylistEntry
+	for _, entry := range entries {
+		if entry.Type == "playlist" {
+			log.Debugln("skipping nested playlist", entry.URL, "in subscription", playlist.ID)
+			continue
+		}
+		videos = append(videos, entry)
+	}
+
+	if playlist.KeepLast > 0 && uint(len(videos)) > playlist.KeepLast {
+		videos = videos[:playlist.KeepLast]
+	}
+
+	for i, j := 0, len(videos)-1; i < j; i, j = i+1, j-1 {
+		videos[i], videos[j] = videos[j], videos[i]
+	}
+	return videos
+}
+
+// delete downloaded entries of a playlist according to its retention rules
+func applyRetention(playlist playlists.Playlist) {
+	if playlist.KeepLast == 0 && playlist.MaxAgeDays == 0 && !playlist.DeleteWatched {
+		return
+	}
+
+	var origs []originals.Original
+	err := db.Where("playlist = ? AND playlist_id = ?", true, playlist.ID).
+		Order("id DESC").Find(&origs).Error
+	if err != nil {
+		log.Errorln("couldn't retrieve entries for playlist", playlist.ID, err)
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -int(playlist.MaxAgeDays))
+	// only completed entries count towards KeepLast, so a refresh doesn't
+	// delete finished entries to make room for downloads that may fail
+	var completed uint
+	for _, orig := range origs {
+		// leave anything that is still being worked on
+		switch orig.Status {
+		case originals.StatusCompleted, originals.StatusFailed, originals.StatusCancelled,
+			originals.StatusQuotaExceeded:
+		default:
+			continue
+		}
+
+		expired := false
+		if playlist.KeepLast > 0 && completed >= playlist.KeepLast {
+			expired = true
+		}
+		if orig.Status == originals.StatusCompleted {
+			completed += 1
+		}
+		if playlist.MaxAgeDays > 0 && orig.CreatedAt.Before(cutoff) {
+			expired = true
+		}
+		if playlist.DeleteWatched && orig.Watched {
+			expired = true
+		}
+
+		if expired {
+			log.Infoln("retention: delete original", orig.ID, "from playlist", playlist.ID)
+			if err := deleteOriginal(orig.ID); err != nil {
+				log.Errorln("couldn't delete original", orig.ID, err)
+			}
+		}
+	}
+}
+
+func applyRetentionRules() {
+	log.Debugln("applyRetentionRules...")
+	var pls []playlists.Playlist
+	err := db.Where("keep_last > ? OR max_age_days > ? OR delete_watched = ?", 0, 0, true).
+		Find(&pls).Error
+	if err != nil {
+		log.Errorln("couldn't retrieve playlists with retention rules:", err)
+		return
+	}
+	for _, pl := range pls {
+		applyRetention(pl)
+	}
+}
+
+// refresh any playlists whose refresh period has elapsed
+func refreshDuePlaylists() {
+	log.Debugln("refreshDuePlaylists...")
+	var pls []playlists.Playlist
+	err := db.Where("refresh_hours > ?", 0).Find(&pls).Error
+	if err != nil {
+		log.Errorln("couldn't retrieve scheduled playlists:", err)
+		return
+	}
+	for _, pl := range pls {
+		due := pl.LastRefresh.Add(time.Duration(pl.RefreshHours) * time.Hour)
+		if time.Now().After(due) {
+			refreshPlaylist(pl.ID)
+		}
+	}
+}
+
+func PeriodicPlaylistRefresh() {
+	refreshDuePlaylists()
+	ticker := time.NewTicker(10 * time.Minute)
+	for range ticker.C {
+		refreshDuePlaylists()
+	}
+}
+
+func refreshPlaylistHandler(c echo.Context) error {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	if _, err := authz.Playlist(c.Get("user_id").(uint), uint(id)); err != nil {
+		return c.String(http.StatusNotFound, "no such playlist")
+	}
+
+	go refreshPlaylist(uint(id))
+
+	referrer := c.Request().Referer()
+	if referrer == "" {
+		referrer = "/videos"
+	}
+	return c.Redirect(http.StatusSeeOther, referrer)
+}
+
+func playlistScheduleHandler(c echo.Context) error {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	if _, err := authz.Playlist(c.Get("user_id").(uint), uint(id)); err != nil {
+		return c.String(http.StatusNotFound, "no such playlist")
+	}
+	hours, _ := strconv.ParseUint(c.FormValue("refresh_hours"), 10, 32)
+
+	err := db.Model(&playlists.Playlist{}).Where("id = ?", id).
+		Update("refresh_hours", hours).Error
+	if err != nil {
+		log.Errorln("couldn't set refresh period for playlist", id, err)
+	}
+
+	referrer := c.Request().Referer()
+	if referrer == "" {
+		referrer = "/videos"
+	}
+	return c.Redirect(http.StatusSeeOther, referrer)
+}
+
+func playlistRetentionHandler(c echo.Context) error {
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	if _, err := authz.Playlist(c.Get("user_id").(uint), uint(id)); err != nil {
+		return c.String(http.StatusNotFound, "no such playlist")
+	}
+	keepLast, _ := strconv.ParseUint(c.FormValue("keep_last"), 10, 32)
+	maxAgeDays, _ := strconv.ParseUint(c.FormValue("max_age_days"), 10, 32)
+	deleteWatched := c.FormValue("delete_watched") == "on"
+
+	err := db.Model(&playlists.Playlist{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"keep_last":      keepLast,
+		"max_age_days":   maxAgeDays,
+		"delete_watched": deleteWatched,
+	}).Error
+	if err != nil {
+		log.Errorln("couldn't set retention for playlist", id, err)
+	}
+
+	var playlist playlists.Playlist
+	if err := db.First(&playlist, id).Error; err == nil {
+		go applyRetention(playlist)
+	}
+
+	referrer := c.Request().Referer()
+	if referrer == "" {
+		referrer = "/videos"
+	}
+	return c.Redirect(http.StatusSeeOther, referrer)
+}
@@ -1,7 +1,10 @@
 package playlists
 
 import (
+	"fmt"
+	"time"
 	"ytdlp-site/database"
+	"ytdlp-site/originals"
 
 	"gorm.io/gorm"
 )
@@ -16,6 +19,19 @@ type Playlist struct {
 	Status Status
 	Audio  bool
 	Video  bool
+
+	ProfileID uint   // Profile.ID applied to entries (0 for the user's profile)
+	SubLangs  string // subtitle languages for entries ("" for none)
+
+	RefreshHours uint      // hours between automatic refreshes (0 for never)
+	LastRefresh  time.Time // last time the entries were retrieved
+
+	Subscription bool // a channel / uploader rather than a fixed playlist
+
+	// retention rules for downloaded entries
+	KeepLast      uint // keep only the newest completed entries (0 for all)
+	MaxAgeDays    uint // delete entries downloaded longer ago than this (0 for never)
+	DeleteWatched bool // delete entries once they are watched
 }
 
 const (
@@ -29,3 +45,46 @@ func SetStatus(id uint, status Status) error {
 	db := database.Get()
 	return db.Model(&Playlist{}).Where("id = ?", id).Update("status", status).Error
 }
+
+// set the playlist status from the statuses of its entries,
+// e.g. "3/12 downloaded, 1 failed", or completed when all entries are downloaded
+func UpdateStatus(id uint) error {
+	db := database.Get()
+
+	var statuses []originals.Status
+	err := db.Model(&originals.Original{}).
+		Where("playlist = ? AND playlist_id = ?", true, id).
+		Pluck("status", &statuses).Error
+	if err != nil {
+		return err
+	}
+
+	var downloaded, failed, cancelled, overQuota int
+	for _, status := range statuses {
+		switch status {
+		case originals.StatusDownloadCompleted, originals.StatusTranscoding, originals.StatusCompleted:
+			downloaded += 1
+		case originals.StatusFailed:
+			failed += 1
+		case originals.StatusCancelled:
+			cancelled += 1
+		case originals.StatusQuotaExceeded:
+			overQuota += 1
+		}
+	}
+
+	if downloaded == len(statuses) {
+		return SetStatus(id, StatusCompleted)
+	}
+	status := fmt.Sprintf("%d/%d downloaded", downloaded, len(statuses))
+	if failed > 0 {
+		status += fmt.Sprintf(", %d failed", failed)
+	}
+	if cancelled > 0 {
+		status += fmt.Sprintf(", %d cancelled", cancelled)
+	}
+	if overQuota > 0 {
+		status += fmt.Sprintf(", %d over quota", overQuota)
+	}
+	return SetStatus(id, Status(status))
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"ytdlp-site/originals"
+	"ytdlp-site/profiles"
+	"ytdlp-site/transcodes"
+	"ytdlp-site/users"
+)
+
+func TestProcessOriginalQuotaExceeded(t *testing.T) {
+	setupTestServer(t)
+	alice := createTestUser(t, "alice")
+	if err := profiles.EnsureDefault(db); err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&alice.video).Updates(map[string]interface{}{"size": 100, "height": 720})
+	db.Model(&users.User{}).Where("id = ?", alice.user.ID).Update("quota_bytes", 100)
+
+	processOriginal(alice.original.ID)
+
+	var orig originals.Original
+	db.First(&orig, alice.original.ID)
+	if orig.Status != originals.StatusQuotaExceeded {
+		t.Errorf("status %q, want %q", orig.Status, originals.StatusQuotaExceeded)
+	}
+	var queued int64
+	db.Model(&transcodes.Transcode{}).Where("original_id = ?", orig.ID).Count(&queued)
+	if queued != 0 {
+		t.Errorf("%d transcodes queued over quota", queued)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"ytdlp-site/handlers"
+	"ytdlp-site/originals"
+	"ytdlp-site/playlists"
+	"ytdlp-site/profiles"
+	"ytdlp-site/users"
+)
+
+var errDeleteDefaultProfile = errors.New("can't delete the default profile, choose another default first")
+
+// delete a profile, moving anything that chose it back to the default
+func deleteProfile(id uint) error {
+	profile, err := profiles.Get(db, id)
+	if err != nil {
+		return err
+	}
+	if profile.Default {
+		return errDeleteDefaultProfile
+	}
+	db.Model(&users.User{}).Where("profile_id = ?", id).Update("profile_id", 0)
+	db.Model(&playlists.Playlist{}).Where("profile_id = ?", id).Update("profile_id", 0)
+	db.Model(&originals.Original{}).Where("profile_id = ?", id).Update("profile_id", 0)
+	return profiles.Delete(db, id)
+}
+
+// the :id profile
+func paramProfile(c echo.Context) (profiles.Profile, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return profiles.Profile{}, echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+	profile, err := profiles.Get(db, uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return profile, echo.NewHTTPError(http.StatusNotFound, "no such profile")
+	} else if err != nil {
+		return profile, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return profile, nil
+}
+
+// a rendition from the add rendition form
+func formRendition(c echo.Context) profiles.Rendition {
+	crf, _ := strconv.ParseUint(c.FormValue("crf"), 10, 32)
+	videoKbps, _ := strconv.ParseUint(c.FormValue("video_kbps"), 10, 32)
+	height, _ := strconv.ParseUint(c.FormValue("height"), 10, 32)
+	fps, _ := strconv.ParseFloat(c.FormValue("fps"), 64)
+	audioKbps, _ := strconv.ParseUint(c.FormValue("audio_kbps"), 10, 32)
+	r := profiles.Rendition{
+		Kind:       c.FormValue("kind"),
+		Container:  c.FormValue("container"),
+		AudioCodec: c.FormValue("audio_codec"),
+		AudioKbps:  uint(audioKbps),
+	}
+	if r.Kind == "video" {
+		r.VideoCodec = c.FormValue("video_codec")
+		r.CRF = uint(crf)
+		r.VideoKbps = uint(videoKbps)
+		r.Preset = c.FormValue("preset")
+		r.Height = uint(height)
+		r.FPS = max(fps, 0)
+	}
+	return r
+}
+
+func profilesHandler(c echo.Context) error {
+	profileList, err := profiles.List(db)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, "Unable to retrieve profiles")
+	}
+	return c.Render(http.StatusOK, "profiles.html", map[string]interface{}{
+		"profiles":        profileList,
+		"videoCodecs":     profiles.VideoCodecs,
+		"audioCodecs":     profiles.AudioCodecs,
+		"videoContainers": profiles.VideoContainers,
+		"audioContainers": profiles.AudioContainers,
+		"presets":         profiles.Presets,
+		"Footer":          handlers.MakeFooter(),
+	})
+}
+
+func profilesPostHandler(c echo.Context) error {
+	profile := profiles.Profile{
+		Name:    c.FormValue("name"),
+		Default: c.FormValue("default") == "on",
+		HLS:     c.FormValue("hls") == "on",
+	}
+	if err := profiles.Create(db, &profile); err != nil {
+		return c.String(http.StatusBadRequest, "Error creating profile: "+err.Error())
+	}
+	return c.Redirect(http.StatusSeeOther, "/profiles")
+}
+
+func profileDefaultHandler(c echo.Context) error {
+	profile, err := paramProfile(c)
+	if err != nil {
+		return err
+	}
+	if err := profiles.SetDefault(db, profile.ID); err != nil {
+		log.Errorln("couldn't set default profile", profile.ID, err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/profiles")
+}
+
+func profileHLSHandler(c echo.Context) error {
+	profile, err := paramProfile(c)
+	if err != nil {
+		return err
+	}
+	if err := profiles.SetHLS(db, profile.ID, c.FormValue("hls") == "true"); err != nil {
+		log.Errorln("couldn't set HLS for profile", profile.ID, err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/profiles")
+}
+
+func profileDeleteHandler(c echo.Context) error {
+	profile, err := paramProfile(c)
+	if err != nil {
+		return err
+	}
+	if err := deleteProfile(profile.ID); errors.Is(err, errDeleteDefaultProfile) {
+		return c.String(http.StatusBadRequest, err.Error())
+	} else if err != nil {
+		log.Errorln("couldn't delete profile", profile.ID, err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/profiles")
+}
+
+func renditionPostHandler(c echo.Context) error {
+	profile, err := paramProfile(c)
+	if err != nil {
+		return err
+	}
+	if _, err := profiles.AddRendition(db, profile.ID, formRendition(c)); err != nil {
+		return c.String(http.StatusBadRequest, "Error adding rendition: "+err.Error())
+	}
+	return c.Redirect(http.StatusSeeOther, "/profiles")
+}
+
+func renditionDeleteHandler(c echo.Context) error {
+	profile, err := paramProfile(c)
+	if err != nil {
+		return err
+	}
+	rid, _ := strconv.ParseUint(c.Param("rid"), 10, 64)
+	err = db.Unscoped().Where("id = ? AND profile_id = ?", rid, profile.ID).Delete(&profiles.Rendition{}).Error
+	if err != nil {
+		log.Errorln("couldn't delete rendition", rid, err)
+	}
+	return c.Redirect(http.StatusSeeOther, "/profiles")
+}
+
+// GET /api/v1/profiles
+func apiProfilesHandler(c echo.Context) error {
+	profileList, err := profiles.List(db)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if profileList == nil {
+		profileList = []profiles.Profile{}
+	}
+	return c.JSON(http.StatusOK, profileList)
+}
+
+type apiRenditionRequest struct {
+	Kind       string  `json:"kind"`
+	VideoCodec string  `json:"video_codec"`
+	CRF        uint    `json:"crf"`
+	VideoKbps  uint    `json:"video_kbps"`
+	Preset     string  `json:"preset"`
+	Height     uint    `json:"height"`
+	FPS        float64 `json:"fps"`
+	Container  string  `json:"container"`
+	AudioCodec string  `json:"audio_codec"`
+	AudioKbps  uint    `json:"audio_kbps"`
+}
+
+func (r apiRenditionRequest) rendition() profiles.Rendition {
+	return profiles.Rendition{
+		Kind:       r.Kind,
+		VideoCodec: r.VideoCodec,
+		CRF:        r.CRF,
+		VideoKbps:  r.VideoKbps,
+		Preset:     r.Preset,
+		Height:     r.Height,
+		FPS:        max(r.FPS, 0),
+		Container:  r.Container,
+		AudioCodec: r.AudioCodec,
+		AudioKbps:  r.AudioKbps,
+	}
+}
+
+type apiProfileRequest struct {
+	Name       string                `json:"name"`
+	Default    bool                  `json:"default"`
+	HLS        bool                  `json:"hls"`
+	Renditions []apiRenditionRequest `json:"renditions"`
+}
+
+// POST /api/v1/profiles
+func apiNewProfileHandler(c echo.Context) error {
+	var req apiProfileRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	profile := profiles.Profile{Name: req.Name, Default: req.Default, HLS: req.HLS}
+	for _, r := range req.Renditions {
+		profile.Renditions = append(profile.Renditions, r.rendition())
+	}
+	if err := profiles.Create(db, &profile); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	profile, _ = profiles.Get(db, profile.ID)
+	return c.JSON(http.StatusCreated, profile)
+}
+
+type apiUpdateProfileRequest struct {
+	Default *bool `json:"default"`
+	HLS     *bool `json:"hls"`
+}
+
+// PATCH /api/v1/profiles/:id
+func apiUpdateProfileHandler(c echo.Context) error {
+	profile, err := paramProfile(c)
+	if err != nil {
+		return err
+	}
+	var req apiUpdateProfileRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if req.Default != nil && *req.Default {
+		if err := profiles.SetDefault(db, profile.ID); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	} else if req.Default != nil && profile.Default {
+		return echo.NewHTTPError(http.StatusBadRequest, "choose another default instead")
+	}
+	if req.HLS != nil {
+		if err := profiles.SetHLS(db, profile.ID, *req.HLS); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+	profile, _ = profiles.Get(db, profile.ID)
+	return c.JSON(http.StatusOK, profile)
+}
+
+// DELETE /api/v1/profiles/:id
+func apiDeleteProfileHandler(c echo.Context) error {
+	profile, err := paramProfile(c)
+	if err != nil {
+		return err
+	}
+	if err := deleteProfile(profile.ID); errors.Is(err, errDeleteDefaultProfile) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// POST /api/v1/profiles/:id/renditions
+func apiNewRenditionHandler(c echo.Context) error {
+	profile, err := paramProfile(c)
+	if err != nil {
+		return err
+	}
+	var req apiRenditionRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	r, err := profiles.AddRendition(db, profile.ID, req.rendition())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusCreated, r)
+}
+
+// DELETE /api/v1/profiles/:id/renditions/:rid
+func apiDeleteRenditionHandler(c echo.Context) error {
+	profile, err := paramProfile(c)
+	if err != nil {
+		return err
+	}
+	rid, err := strconv.ParseUint(c.Param("rid"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid id")
+	}
+	result := db.Unscoped().Where("id = ? AND profile_id = ?", rid, profile.ID).Delete(&profiles.Rendition{})
+	if result.Error != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, result.Error.Error())
+	} else if result.RowsAffected == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "no such rendition")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
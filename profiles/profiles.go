@@ -0,0 +1,252 @@
+// Package profiles stores the named transcode ladders that are applied to
+// an original once it has been downloaded.
+package profiles
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// a named set of renditions
+type Profile struct {
+	gorm.Model
+	Name       string `gorm:"uniqueIndex"`
+	Default    bool   // used when neither the download nor the user chose a profile
+	HLS        bool   // package the video renditions for adaptive streaming
+	Renditions []Rendition
+}
+
+// one output of a profile
+type Rendition struct {
+	gorm.Model
+	ProfileID uint
+	Kind      string // "video" or "audio"
+
+	// video fields
+	VideoCodec string
+	CRF        uint    // constant quality, used if VideoKbps is 0
+	VideoKbps  uint    // target bitrate
+	Preset     string  // encoder speed preset
+	Height     uint    // maximum height, 0 for the source height
+	FPS        float64 // 0 for the source FPS
+
+	// audio & video fields
+	Container  string
+	AudioCodec string
+	AudioKbps  uint // ignored for flac
+}
+
+// codecs and containers that the transcoders know how to produce
+var (
+	VideoCodecs     = []string{"h264", "hevc", "vp9", "av1"}
+	AudioCodecs     = []string{"aac", "opus", "mp3", "flac"}
+	VideoContainers = []string{"mp4", "webm", "mkv"}
+	AudioContainers = []string{"m4a", "opus", "mp3", "flac"}
+	Presets         = []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium", "slow", "slower", "veryslow"}
+)
+
+// video and audio codecs each video container can hold
+var videoContainerCodecs = map[string]struct{ video, audio []string }{
+	"mp4":  {[]string{"h264", "hevc", "av1"}, []string{"aac", "opus", "mp3"}},
+	"webm": {[]string{"vp9", "av1"}, []string{"opus"}},
+	"mkv":  {[]string{"h264", "hevc", "vp9", "av1"}, []string{"aac", "opus", "mp3", "flac"}},
+}
+
+// audio codecs each audio-only container can hold
+var audioContainerCodecs = map[string][]string{
+	"m4a":  {"aac"},
+	"opus": {"opus"},
+	"mp3":  {"mp3"},
+	"flac": {"flac"},
+}
+
+// MaxCRF is the highest (worst) quality setting of a video codec
+func MaxCRF(codec string) uint {
+	switch codec {
+	case "vp9", "av1":
+		return 63
+	default:
+		return 51
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rendition) Validate() error {
+	if !contains(AudioCodecs, r.AudioCodec) {
+		return fmt.Errorf("unsupported audio codec %q", r.AudioCodec)
+	}
+	switch r.Kind {
+	case "video":
+		if !contains(VideoCodecs, r.VideoCodec) {
+			return fmt.Errorf("unsupported video codec %q", r.VideoCodec)
+		}
+		codecs, ok := videoContainerCodecs[r.Container]
+		if !ok {
+			return fmt.Errorf("unsupported video container %q", r.Container)
+		}
+		if !contains(codecs.video, r.VideoCodec) || !contains(codecs.audio, r.AudioCodec) {
+			return fmt.Errorf("%s can't hold %s video with %s audio", r.Container, r.VideoCodec, r.AudioCodec)
+		}
+		if r.Preset != "" && !contains(Presets, r.Preset) {
+			return fmt.Errorf("unsupported preset %q", r.Preset)
+		}
+		if r.CRF > MaxCRF(r.VideoCodec) {
+			return fmt.Errorf("CRF %d out of range for %s", r.CRF, r.VideoCodec)
+		}
+	case "audio":
+		if !contains(AudioContainers, r.Container) {
+			return fmt.Errorf("unsupported audio container %q", r.Container)
+		}
+		if !contains(audioContainerCodecs[r.Container], r.AudioCodec) {
+			return fmt.Errorf("%s can't hold %s audio", r.Container, r.AudioCodec)
+		}
+	default:
+		return fmt.Errorf("kind must be \"video\" or \"audio\"")
+	}
+	return nil
+}
+
+// the ladder that was hard-coded before profiles existed
+func builtinDefault() Profile {
+	return Profile{
+		Name:    "default",
+		Default: true,
+		Renditions: []Rendition{
+			{Kind: "video", VideoCodec: "h264", CRF: 23, Preset: "fast", Height: 540,
+				Container: "mp4", AudioCodec: "aac", AudioKbps: 96},
+			{Kind: "audio", Container: "mp3", AudioCodec: "mp3", AudioKbps: 64},
+		},
+	}
+}
+
+// EnsureDefault creates the default profile if there are no profiles
+func EnsureDefault(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&Profile{}).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	p := builtinDefault()
+	return db.Create(&p).Error
+}
+
+func Get(db *gorm.DB, id uint) (Profile, error) {
+	var p Profile
+	err := db.Preload("Renditions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("kind DESC, height DESC, audio_kbps DESC")
+	}).First(&p, id).Error
+	return p, err
+}
+
+func List(db *gorm.DB) ([]Profile, error) {
+	var ps []Profile
+	err := db.Preload("Renditions", func(db *gorm.DB) *gorm.DB {
+		return db.Order("kind DESC, height DESC, audio_kbps DESC")
+	}).Order("name ASC").Find(&ps).Error
+	return ps, err
+}
+
+// Resolve returns the first profile that exists out of the download's choice,
+// the user's choice, and the default profile
+func Resolve(db *gorm.DB, ids ...uint) (Profile, error) {
+	for _, id := range ids {
+		if id == 0 {
+			continue
+		}
+		p, err := Get(db, id)
+		if err == nil {
+			return p, nil
+		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return Profile{}, err
+		}
+	}
+
+	var def Profile
+	err := db.Where("`default` = ?", true).First(&def).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return builtinDefault(), nil
+	} else if err != nil {
+		return Profile{}, err
+	}
+	return Get(db, def.ID)
+}
+
+// Create validates and stores a profile with its renditions
+func Create(db *gorm.DB, p *Profile) error {
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	for _, r := range p.Renditions {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if p.Default {
+			if err := tx.Model(&Profile{}).Where("`default` = ?", true).Update("default", false).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(p).Error
+	})
+}
+
+func AddRendition(db *gorm.DB, profileID uint, r Rendition) (Rendition, error) {
+	if err := r.Validate(); err != nil {
+		return r, err
+	}
+	if _, err := Get(db, profileID); err != nil {
+		return r, err
+	}
+	r.ProfileID = profileID
+	err := db.Create(&r).Error
+	return r, err
+}
+
+func DeleteRendition(db *gorm.DB, id uint) error {
+	return db.Unscoped().Delete(&Rendition{}, id).Error
+}
+
+func SetDefault(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&Profile{}).Where("`default` = ?", true).Update("default", false).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&Profile{}).Where("id = ?", id).Update("default", true)
+		if result.Error == nil && result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return result.Error
+	})
+}
+
+// SetHLS sets whether a profile's video renditions are packaged for adaptive streaming
+func SetHLS(db *gorm.DB, id uint, hls bool) error {
+	result := db.Model(&Profile{}).Where("id = ?", id).Update("hls", hls)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+// Delete removes a profile. Users and downloads that chose it fall back to the default
+func Delete(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("profile_id = ?", id).Delete(&Rendition{}).Error; err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&Profile{}, id).Error
+	})
+}
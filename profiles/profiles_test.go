@@ -0,0 +1,47 @@
+package profiles
+
+import "testing"
+
+func TestRenditionValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		r    Rendition
+		ok   bool
+	}{
+		{"h264 mp4", Rendition{Kind: "video", VideoCodec: "h264", Container: "mp4", AudioCodec: "aac", CRF: 23, Preset: "fast"}, true},
+		{"vp9 webm", Rendition{Kind: "video", VideoCodec: "vp9", Container: "webm", AudioCodec: "opus", CRF: 63}, true},
+		{"av1 mkv with flac", Rendition{Kind: "video", VideoCodec: "av1", Container: "mkv", AudioCodec: "flac"}, true},
+		{"hevc mp4", Rendition{Kind: "video", VideoCodec: "hevc", Container: "mp4", AudioCodec: "aac"}, true},
+		{"vp9 in mp4", Rendition{Kind: "video", VideoCodec: "vp9", Container: "mp4", AudioCodec: "aac"}, false},
+		{"aac in webm", Rendition{Kind: "video", VideoCodec: "vp9", Container: "webm", AudioCodec: "aac"}, false},
+		{"flac in mp4", Rendition{Kind: "video", VideoCodec: "h264", Container: "mp4", AudioCodec: "flac"}, false},
+		{"unknown video codec", Rendition{Kind: "video", VideoCodec: "mpeg2", Container: "mkv", AudioCodec: "aac"}, false},
+		{"unknown video container", Rendition{Kind: "video", VideoCodec: "h264", Container: "avi", AudioCodec: "aac"}, false},
+		{"unknown preset", Rendition{Kind: "video", VideoCodec: "h264", Container: "mp4", AudioCodec: "aac", Preset: "warp"}, false},
+		{"h264 CRF out of range", Rendition{Kind: "video", VideoCodec: "h264", Container: "mp4", AudioCodec: "aac", CRF: 52}, false},
+		{"av1 CRF in range", Rendition{Kind: "video", VideoCodec: "av1", Container: "webm", AudioCodec: "opus", CRF: 52}, true},
+		{"mp3 audio", Rendition{Kind: "audio", Container: "mp3", AudioCodec: "mp3", AudioKbps: 64}, true},
+		{"aac in m4a", Rendition{Kind: "audio", Container: "m4a", AudioCodec: "aac"}, true},
+		{"opus audio", Rendition{Kind: "audio", Container: "opus", AudioCodec: "opus"}, true},
+		{"flac audio", Rendition{Kind: "audio", Container: "flac", AudioCodec: "flac"}, true},
+		{"aac in mp3", Rendition{Kind: "audio", Container: "mp3", AudioCodec: "aac"}, false},
+		{"video container for audio", Rendition{Kind: "audio", Container: "mp4", AudioCodec: "aac"}, false},
+		{"unknown audio codec", Rendition{Kind: "audio", Container: "mp3", AudioCodec: "vorbis"}, false},
+		{"unknown kind", Rendition{Kind: "image", Container: "mp3", AudioCodec: "mp3"}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.r.Validate(); (err == nil) != tc.ok {
+				t.Errorf("Validate() = %v, want ok %t", err, tc.ok)
+			}
+		})
+	}
+}
+
+func TestBuiltinDefaultIsValid(t *testing.T) {
+	for _, r := range builtinDefault().Renditions {
+		if err := r.Validate(); err != nil {
+			t.Errorf("%s rendition: %v", r.Kind, err)
+		}
+	}
+}
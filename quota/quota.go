@@ -0,0 +1,174 @@
+// Package quota tracks how much storage each user's media takes up and
+// enforces users.User.QuotaBytes and users.User.QuotaItems.
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"ytdlp-site/database"
+	"ytdlp-site/media"
+	"ytdlp-site/originals"
+	"ytdlp-site/users"
+)
+
+var ErrExceeded = errors.New("quota exceeded")
+
+// storage used by one original's media
+type OriginalUsage struct {
+	OriginalID    uint
+	Title         string
+	VideoBytes    int64
+	AudioBytes    int64
+	ClipBytes     int64
+	SubtitleBytes int64
+	Bytes         int64
+	NumMedia      int64
+	PlaylistID    uint
+	InPlaylist    bool
+	Unprocessed   bool // no media yet
+}
+
+type Usage struct {
+	VideoBytes    int64
+	AudioBytes    int64
+	ClipBytes     int64
+	SubtitleBytes int64
+	Bytes         int64 // total of the above
+	Items         int64 // originals with at least one media file
+
+	Originals []OriginalUsage // largest first
+}
+
+type sizeRow struct {
+	OriginalID uint
+	Bytes      int64
+	Count      int64
+}
+
+// sum the size of a media table per original, for a user's originals
+func sizesByOriginal(model interface{}, userID uint) (map[uint]sizeRow, error) {
+	db := database.Get()
+	var rows []sizeRow
+	err := db.Model(model).
+		Select("original_id, SUM(size) AS bytes, COUNT(*) AS count").
+		Where("original_id IN (?)",
+			db.Model(&originals.Original{}).Select("id").Where("user_id = ?", userID)).
+		Group("original_id").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	m := map[uint]sizeRow{}
+	for _, r := range rows {
+		m[r.OriginalID] = r
+	}
+	return m, nil
+}
+
+// GetUsage returns a user's storage usage, broken down by original
+func GetUsage(userID uint) (Usage, error) {
+	var usage Usage
+	videos, err := sizesByOriginal(&media.Video{}, userID)
+	if err != nil {
+		return usage, err
+	}
+	audios, err := sizesByOriginal(&media.Audio{}, userID)
+	if err != nil {
+		return usage, err
+	}
+	clips, err := sizesByOriginal(&media.VideoClip{}, userID)
+	if err != nil {
+		return usage, err
+	}
+	audioClips, err := sizesByOriginal(&media.AudioClip{}, userID)
+	if err != nil {
+		return usage, err
+	}
+	streams, err := sizesByOriginal(&media.HLS{}, userID)
+	if err != nil {
+		return usage, err
+	}
+	subtitles, err := sizesByOriginal(&media.Subtitle{}, userID)
+	if err != nil {
+		return usage, err
+	}
+
+	var origs []originals.Original
+	err = database.Get().Where("user_id = ?", userID).Order("id DESC").Find(&origs).Error
+	if err != nil {
+		return usage, err
+	}
+
+	for _, orig := range origs {
+		v, a, c, s := videos[orig.ID], audios[orig.ID], clips[orig.ID], subtitles[orig.ID]
+		c.Bytes += audioClips[orig.ID].Bytes
+		c.Count += audioClips[orig.ID].Count
+		ou := OriginalUsage{
+			OriginalID:    orig.ID,
+			Title:         orig.Title,
+			VideoBytes:    v.Bytes + streams[orig.ID].Bytes, // HLS packages are copies of the videos
+			AudioBytes:    a.Bytes,
+			ClipBytes:     c.Bytes,
+			SubtitleBytes: s.Bytes,
+			Bytes:         v.Bytes + streams[orig.ID].Bytes + a.Bytes + c.Bytes + s.Bytes,
+			NumMedia:      v.Count + a.Count + c.Count + s.Count,
+			PlaylistID:    orig.PlaylistID,
+			InPlaylist:    orig.Playlist,
+		}
+		ou.Unprocessed = ou.NumMedia == 0
+
+		usage.VideoBytes += ou.VideoBytes
+		usage.AudioBytes += ou.AudioBytes
+		usage.ClipBytes += ou.ClipBytes
+		usage.SubtitleBytes += ou.SubtitleBytes
+		if !ou.Unprocessed {
+			usage.Items += 1
+		}
+		usage.Originals = append(usage.Originals, ou)
+	}
+	usage.Bytes = usage.VideoBytes + usage.AudioBytes + usage.ClipBytes + usage.SubtitleBytes
+
+	sort.SliceStable(usage.Originals, func(i, j int) bool {
+		return usage.Originals[i].Bytes > usage.Originals[j].Bytes
+	})
+	return usage, nil
+}
+
+func getUser(userID uint) (users.User, error) {
+	var user users.User
+	err := database.Get().First(&user, userID).Error
+	return user, err
+}
+
+// CheckDownload returns ErrExceeded if a user may not download another original
+func CheckDownload(userID uint) error {
+	return check(userID, true)
+}
+
+// CheckTranscode returns ErrExceeded if a user may not create more media
+func CheckTranscode(userID uint) error {
+	return check(userID, false)
+}
+
+func check(userID uint, newItem bool) error {
+	user, err := getUser(userID)
+	if err != nil {
+		return err
+	}
+	if user.QuotaBytes == 0 && user.QuotaItems == 0 {
+		return nil
+	}
+	usage, err := GetUsage(userID)
+	if err != nil {
+		return err
+	}
+	if user.QuotaBytes != 0 && usage.Bytes >= user.QuotaBytes {
+		return fmt.Errorf("%w: using %d of %d bytes", ErrExceeded, usage.Bytes, user.QuotaBytes)
+	}
+	if newItem && user.QuotaItems != 0 && usage.Items >= int64(user.QuotaItems) {
+		return fmt.Errorf("%w: %d of %d items", ErrExceeded, usage.Items, user.QuotaItems)
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package quota
+
+import (
+	"testing"
+
+	"ytdlp-site/database/dbtest"
+	"ytdlp-site/media"
+	"ytdlp-site/originals"
+	"ytdlp-site/users"
+)
+
+func TestGetUsage(t *testing.T) {
+	db := dbtest.Open(t, &users.User{}, &originals.Original{}, &media.Video{}, &media.Audio{},
+		&media.VideoClip{}, &media.AudioClip{}, &media.Subtitle{}, &media.HLS{})
+	alice, _ := users.CreateUser(db, "alice", "password", false)
+	bob, _ := users.CreateUser(db, "bob", "password", false)
+
+	orig := originals.Original{UserID: alice.ID, Title: "everything"}
+	db.Create(&orig)
+	file := func(size int64) media.MediaFile { return media.MediaFile{Size: size} }
+	db.Create(&media.Video{OriginalID: orig.ID, VideoFile: media.VideoFile{MediaFile: file(1000)}})
+	db.Create(&media.HLS{OriginalID: orig.ID, Size: 900})
+	db.Create(&media.Audio{OriginalID: orig.ID, MediaFile: file(100)})
+	db.Create(&media.VideoClip{OriginalID: orig.ID, VideoFile: media.VideoFile{MediaFile: file(50)}})
+	db.Create(&media.AudioClip{OriginalID: orig.ID, MediaFile: file(5)})
+	db.Create(&media.Subtitle{OriginalID: orig.ID, MediaFile: file(7)})
+	db.Create(&media.Subtitle{OriginalID: orig.ID, MediaFile: file(3)})
+
+	// only subtitles so far, which still take up space
+	subsOnly := originals.Original{UserID: alice.ID, Title: "subtitles"}
+	db.Create(&subsOnly)
+	db.Create(&media.Subtitle{OriginalID: subsOnly.ID, MediaFile: file(20)})
+
+	unprocessed := originals.Original{UserID: alice.ID, Title: "nothing yet"}
+	db.Create(&unprocessed)
+
+	other := originals.Original{UserID: bob.ID}
+	db.Create(&other)
+	db.Create(&media.Video{OriginalID: other.ID, VideoFile: media.VideoFile{MediaFile: file(99999)}})
+	db.Create(&media.Subtitle{OriginalID: other.ID, MediaFile: file(99999)})
+
+	usage, err := GetUsage(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Usage{VideoBytes: 1900, AudioBytes: 100, ClipBytes: 55, SubtitleBytes: 30, Bytes: 2085, Items: 2}
+	if usage.VideoBytes != want.VideoBytes || usage.AudioBytes != want.AudioBytes ||
+		usage.ClipBytes != want.ClipBytes || usage.SubtitleBytes != want.SubtitleBytes ||
+		usage.Bytes != want.Bytes || usage.Items != want.Items {
+		t.Errorf("got %+v, want %+v", usage, want)
+	}
+
+	if len(usage.Originals) != 3 {
+		t.Fatalf("got %d originals, want 3", len(usage.Originals))
+	}
+	first := usage.Originals[0]
+	if first.OriginalID != orig.ID || first.Bytes != 2065 || first.SubtitleBytes != 10 || first.NumMedia != 6 {
+		t.Errorf("largest original %+v, want %d with 2065 bytes, 10 of subtitles, in 6 media", first, orig.ID)
+	}
+	for _, ou := range usage.Originals[1:] {
+		if unproc := ou.OriginalID == unprocessed.ID; ou.Unprocessed != unproc {
+			t.Errorf("original %d Unprocessed = %t, want %t", ou.OriginalID, ou.Unprocessed, unproc)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"ytdlp-site/config"
+	"ytdlp-site/handlers"
+	"ytdlp-site/media"
+)
+
+type ReconcileMode string
+
+const (
+	ReconcileOnly       ReconcileMode = "report"     // only list orphans
+	ReconcileDelete     ReconcileMode = "delete"     // delete orphan files and dangling records
+	ReconcileQuarantine ReconcileMode = "quarantine" // move orphan files aside, delete dangling records
+)
+
+const (
+	quarantineDirName = "quarantine"
+	// files newer than this may belong to a transcode that hasn't created its record yet
+	orphanGracePeriod = time.Hour
+)
+
+// a file or directory in the data directory that no record refers to
+type OrphanFile struct {
+	Name   string
+	Size   int64
+	Action string // what was done with it, empty if nothing
+}
+
+// a media record whose file is missing
+type DanglingRecord struct {
+	Kind       string // "video", "audio", "clip", "audio clip", "subtitle", "hls"
+	ID         uint
+	OriginalID uint
+	Filename   string
+	Action     string
+}
+
+type ReconcileReport struct {
+	Start    time.Time
+	Duration time.Duration
+	Mode     ReconcileMode
+	Files    []OrphanFile
+	Records  []DanglingRecord
+	Errors   []string
+}
+
+var reconcileMu sync.Mutex // one reconciler at a time
+var lastReconcile *ReconcileReport
+
+// temporary download directories that are in use
+var activeTempDirs = map[string]struct{}{}
+var tempDirsMu sync.Mutex
+
+func trackTempDir(dir string) {
+	tempDirsMu.Lock()
+	defer tempDirsMu.Unlock()
+	activeTempDirs[filepath.Base(dir)] = struct{}{}
+}
+
+func untrackTempDir(dir string) {
+	tempDirsMu.Lock()
+	defer tempDirsMu.Unlock()
+	delete(activeTempDirs, filepath.Base(dir))
+}
+
+func tempDirActive(name string) bool {
+	tempDirsMu.Lock()
+	defer tempDirsMu.Unlock()
+	_, ok := activeTempDirs[name]
+	return ok
+}
+
+func parseReconcileMode(s string) (ReconcileMode, error) {
+	switch mode := ReconcileMode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case ReconcileOnly, ReconcileDelete, ReconcileQuarantine:
+		return mode, nil
+	case "":
+		return ReconcileOnly, nil
+	default:
+		return "", fmt.Errorf("unknown reconcile mode %q", s)
+	}
+}
+
+// the total size of a file, or of everything under a directory
+func pathSize(path string) int64 {
+	var size int64
+	filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// an absolute path with its directory's symlinks resolved, so paths to the
+// same file compare equal even if the file doesn't exist yet
+func resolvePath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		return filepath.Join(dir, filepath.Base(abs))
+	}
+	return abs
+}
+
+// the database and its journal files, which must never be touched even if
+// the config directory is the data directory
+func databasePaths() []string {
+	dbPath := config.GetDatabasePath()
+	var paths []string
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		paths = append(paths, resolvePath(dbPath+suffix))
+	}
+	return paths
+}
+
+// the names of entries in the data directory that must never be touched,
+// and whether any database file is directly in the data directory, where
+// fixing orphans could move or delete the live database
+func reservedNames() (map[string]bool, bool) {
+	reserved := map[string]bool{quarantineDirName: true}
+	dataDir := resolvePath(config.GetDataDir())
+	topLevel := false
+	for i, path := range append([]string{resolvePath(config.GetConfigDir())}, databasePaths()...) {
+		rel, err := filepath.Rel(dataDir, path)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue // the data directory itself, or outside it
+		}
+		first := strings.Split(rel, string(filepath.Separator))[0]
+		reserved[first] = true
+		if i > 0 && first == rel {
+			topLevel = true
+		}
+	}
+	return reserved, topLevel
+}
+
+// filenames that some media record refers to
+func knownFiles() (map[string]bool, error) {
+	known := map[string]bool{}
+	for _, model := range []interface{}{&media.Video{}, &media.Audio{}, &media.VideoClip{}, &media.AudioClip{}, &media.Subtitle{}} {
+		var names []string
+		if err := db.Model(model).Pluck("filename", &names).Error; err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			known[name] = true
+		}
+	}
+	var dirnames []string
+	if err := db.Model(&media.HLS{}).Pluck("dirname", &dirnames).Error; err != nil {
+		return nil, err
+	}
+	for _, name := range dirnames {
+		known[name] = true
+	}
+	return known, nil
+}
+
+func findOrphanFiles(report *ReconcileReport) {
+	dataDir := config.GetDataDir()
+	known, err := knownFiles()
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return
+	}
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return
+	}
+	reserved, _ := reservedNames()
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if reserved[name] || known[name] {
+			continue
+		}
+		if entry.IsDir() && strings.HasPrefix(name, "dl") && tempDirActive(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < orphanGracePeriod {
+			continue
+		}
+		report.Files = append(report.Files, OrphanFile{
+			Name: name,
+			Size: pathSize(filepath.Join(dataDir, name)),
+		})
+	}
+}
+
+func findDanglingRecords(report *ReconcileReport) {
+	dataDir := config.GetDataDir()
+	missing := func(filename string) bool {
+		_, err := os.Stat(filepath.Join(dataDir, filename))
+		return os.IsNotExist(err)
+	}
+
+	var videos []media.Video
+	db.Find(&videos)
+	for _, v := range videos {
+		if missing(v.Filename) {
+			report.Records = append(report.Records, DanglingRecord{"video", v.ID, v.OriginalID, v.Filename, ""})
+		}
+	}
+	var audios []media.Audio
+	db.Find(&audios)
+	for _, a := range audios {
+		if missing(a.Filename) {
+			report.Records = append(report.Records, DanglingRecord{"audio", a.ID, a.OriginalID, a.Filename, ""})
+		}
+	}
+	var clips []media.VideoClip
+	db.Find(&clips)
+	for _, c := range clips {
+		if missing(c.Filename) {
+			report.Records = append(report.Records, DanglingRecord{"clip", c.ID, c.OriginalID, c.Filename, ""})
+		}
+	}
+	var audioClips []media.AudioClip
+	db.Find(&audioClips)
+	for _, c := range audioClips {
+		if missing(c.Filename) {
+			report.Records = append(report.Records, DanglingRecord{"audio clip", c.ID, c.OriginalID, c.Filename, ""})
+		}
+	}
+	var subtitles []media.Subtitle
+	db.Find(&subtitles)
+	for _, s := range subtitles {
+		if missing(s.Filename) {
+			report.Records = append(report.Records, DanglingRecord{"subtitle", s.ID, s.OriginalID, s.Filename, ""})
+		}
+	}
+	var packages []media.HLS
+	db.Where("status = ?", media.Completed).Find(&packages)
+	for _, h := range packages {
+		if missing(filepath.Join(h.Dirname, hlsMaster)) {
+			report.Records = append(report.Records, DanglingRecord{"hls", h.ID, h.OriginalID, h.Dirname, ""})
+		}
+	}
+}
+
+func fixOrphanFiles(report *ReconcileReport) {
+	dataDir := config.GetDataDir()
+	quarantineDir := filepath.Join(dataDir, quarantineDirName, report.Start.Format("20060102-150405"))
+
+	for i := range report.Files {
+		f := &report.Files[i]
+		src := filepath.Join(dataDir, f.Name)
+		switch report.Mode {
+		case ReconcileDelete:
+			if err := os.RemoveAll(src); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			f.Action = "deleted"
+		case ReconcileQuarantine:
+			if err := os.MkdirAll(quarantineDir, 0700); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				return
+			}
+			if err := os.Rename(src, filepath.Join(quarantineDir, f.Name)); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			f.Action = "moved to " + filepath.Join(quarantineDirName, filepath.Base(quarantineDir))
+		}
+		log.Infoln("reconcile: orphan file", f.Name, f.Action)
+	}
+}
+
+func fixDanglingRecords(report *ReconcileReport) {
+	for i := range report.Records {
+		r := &report.Records[i]
+		var err error
+		switch r.Kind {
+		case "video":
+			err = db.Delete(&media.Video{}, r.ID).Error
+		case "audio":
+			err = db.Delete(&media.Audio{}, r.ID).Error
+		case "clip":
+			err = db.Delete(&media.VideoClip{}, r.ID).Error
+		case "audio clip":
+			err = db.Delete(&media.AudioClip{}, r.ID).Error
+		case "subtitle":
+			err = db.Delete(&media.Subtitle{}, r.ID).Error
+		case "hls":
+			err = db.Delete(&media.HLS{}, r.ID).Error
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		r.Action = "deleted"
+		log.Infoln("reconcile: deleted", r.Kind, r.ID, "for missing", r.Filename)
+	}
+}
+
+// find files without records and records without files, and fix them according to mode
+func reconcile(mode ReconcileMode) ReconcileReport {
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+
+	report := ReconcileReport{Start: time.Now(), Mode: mode}
+	if _, topLevel := reservedNames(); topLevel && mode != ReconcileOnly {
+		report.Errors = append(report.Errors, fmt.Sprintf("not running in %s mode: the database is in the top level "+
+			"of the data directory, set YTDLP_SITE_CONFIG_DIR to a subdirectory or another directory", mode))
+		mode = ReconcileOnly
+		report.Mode = mode
+	}
+	findOrphanFiles(&report)
+	findDanglingRecords(&report)
+	if mode != ReconcileOnly {
+		fixOrphanFiles(&report)
+		fixDanglingRecords(&report)
+	}
+	report.Duration = time.Since(report.Start)
+
+	log.Infof("reconcile (%s): %d orphan files, %d dangling records, %d errors",
+		mode, len(report.Files), len(report.Records), len(report.Errors))
+	lastReconcile = &report
+	return report
+}
+
+// run the reconciler in the configured mode
+func reconcileScheduled() {
+	mode, err := parseReconcileMode(config.GetReconcileMode())
+	if err != nil {
+		log.Errorln(err)
+		mode = ReconcileOnly
+	}
+	reconcile(mode)
+}
+
+func getLastReconcile() *ReconcileReport {
+	reconcileMu.Lock()
+	defer reconcileMu.Unlock()
+	return lastReconcile
+}
+
+func reconcileHandler(c echo.Context) error {
+	return c.Render(http.StatusOK, "reconcile.html", map[string]interface{}{
+		"report": getLastReconcile(),
+		"Footer": handlers.MakeFooter(),
+	})
+}
+
+func reconcilePostHandler(c echo.Context) error {
+	mode, err := parseReconcileMode(c.FormValue("mode"))
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	reconcile(mode)
+	return c.Redirect(http.StatusSeeOther, "/reconcile")
+}
+
+// GET /api/v1/reconcile
+func apiReconcileHandler(c echo.Context) error {
+	report := getLastReconcile()
+	if report == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "the reconciler has not run yet")
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// POST /api/v1/reconcile?mode=report|delete|quarantine
+func apiRunReconcileHandler(c echo.Context) error {
+	mode, err := parseReconcileMode(c.QueryParam("mode"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.JSON(http.StatusOK, reconcile(mode))
+}
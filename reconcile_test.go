@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReservedNames(t *testing.T) {
+	dataDir := t.TempDir()
+	link := filepath.Join(t.TempDir(), "config")
+	if err := os.Symlink(dataDir, link); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		configDir string // "" for the default
+		reserved  []string
+		topLevel  bool
+	}{
+		{"default config dir", "", []string{"config"}, false},
+		{"nested config dir", filepath.Join(dataDir, "etc", "site"), []string{"etc"}, false},
+		{"config dir elsewhere", t.TempDir(), nil, false},
+		{"config dir is the data dir", dataDir,
+			[]string{"videos.db", "videos.db-wal", "videos.db-shm", "videos.db-journal"}, true},
+		{"config dir links to the data dir", link,
+			[]string{"videos.db", "videos.db-wal", "videos.db-shm", "videos.db-journal"}, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("YTDLP_SITE_DATA_DIR", dataDir)
+			// Setenv first so the variable is restored afterwards
+			t.Setenv("YTDLP_SITE_CONFIG_DIR", tc.configDir)
+			if tc.configDir == "" {
+				os.Unsetenv("YTDLP_SITE_CONFIG_DIR")
+			}
+
+			reserved, topLevel := reservedNames()
+			want := append([]string{quarantineDirName}, tc.reserved...)
+			for _, name := range want {
+				if !reserved[name] {
+					t.Errorf("%s isn't reserved", name)
+				}
+			}
+			if len(reserved) != len(want) {
+				t.Errorf("reserved %v, want %v", reserved, want)
+			}
+			if topLevel != tc.topLevel {
+				t.Errorf("topLevel = %t, want %t", topLevel, tc.topLevel)
+			}
+		})
+	}
+}
+
+func TestReconcileKeepsDatabaseInDataDir(t *testing.T) {
+	setupTestServer(t)
+	dataDir := os.Getenv("YTDLP_SITE_DATA_DIR")
+	t.Setenv("YTDLP_SITE_CONFIG_DIR", dataDir)
+
+	// old enough to be an orphan if it weren't reserved
+	old := time.Now().Add(-2 * orphanGracePeriod)
+	for _, name := range []string{"videos.db", "videos.db-wal", "orphan.mp4"} {
+		path := filepath.Join(dataDir, name)
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := reconcile(ReconcileDelete)
+	if report.Mode != ReconcileOnly || len(report.Errors) == 0 {
+		t.Errorf("ran in %s mode with errors %v, want a refusal to fix", report.Mode, report.Errors)
+	}
+	for _, name := range []string{"videos.db", "videos.db-wal", "orphan.mp4"} {
+		if _, err := os.Stat(filepath.Join(dataDir, name)); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if len(report.Files) != 1 || report.Files[0].Name != "orphan.mp4" {
+		t.Errorf("orphans %v, want only orphan.mp4", report.Files)
+	}
+}
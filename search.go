@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"ytdlp-site/handlers"
+	"ytdlp-site/originals"
+	"ytdlp-site/playlists"
+	"ytdlp-site/search"
+)
+
+const searchDateLayout = "2006-01-02"
+
+// a search from the q, status, watched, kind, playlist_id, from, to, page
+// and per_page query parameters
+func parseSearchQuery(c echo.Context) (search.Query, error) {
+	q := search.Query{
+		Text:   c.QueryParam("q"),
+		Status: originals.Status(c.QueryParam("status")),
+		Kind:   c.QueryParam("kind"),
+	}
+	if q.Kind != "" && q.Kind != "audio" && q.Kind != "video" {
+		return q, fmt.Errorf(`kind must be "audio" or "video"`)
+	}
+	if q.Status != "" && !slices.Contains(originals.Statuses, q.Status) {
+		return q, fmt.Errorf("unknown status %q", q.Status)
+	}
+	if s := c.QueryParam("watched"); s != "" {
+		watched, err := strconv.ParseBool(s)
+		if err != nil {
+			return q, fmt.Errorf("invalid watched")
+		}
+		q.Watched = &watched
+	}
+	if s := c.QueryParam("playlist_id"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return q, fmt.Errorf("invalid playlist_id")
+		}
+		q.PlaylistID = uint(id)
+	}
+	if s := c.QueryParam("from"); s != "" {
+		from, err := time.Parse(searchDateLayout, s)
+		if err != nil {
+			return q, fmt.Errorf("from must be YYYY-MM-DD")
+		}
+		q.From = from
+	}
+	if s := c.QueryParam("to"); s != "" {
+		to, err := time.Parse(searchDateLayout, s)
+		if err != nil {
+			return q, fmt.Errorf("to must be YYYY-MM-DD")
+		}
+		q.To = to.AddDate(0, 0, 1) // include the whole day
+	}
+	if s := c.QueryParam("page"); s != "" {
+		q.Page, _ = strconv.Atoi(s)
+	}
+	if s := c.QueryParam("per_page"); s != "" {
+		q.PerPage, _ = strconv.Atoi(s)
+	}
+	return q, nil
+}
+
+// the current search URL on another page
+func searchPageURL(c echo.Context, page int) string {
+	params := url.Values{}
+	for k, v := range c.QueryParams() {
+		params[k] = v
+	}
+	params.Set("page", strconv.Itoa(page))
+	return "/search?" + params.Encode()
+}
+
+func searchHandler(c echo.Context) error {
+	userID := c.Get("user_id").(uint)
+	q, err := parseSearchQuery(c)
+	if err != nil {
+		return c.String(http.StatusBadRequest, err.Error())
+	}
+	results, err := search.Search(userID, q)
+	if err != nil {
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+
+	var pls []playlists.Playlist
+	db.Where("user_id = ?", userID).Order("id DESC").Find(&pls)
+
+	data := map[string]interface{}{
+		"query":     c.QueryParams(),
+		"results":   results,
+		"playlists": pls,
+		"fullText":  search.FullText(),
+		"statuses":  originals.Statuses,
+		"Footer":    handlers.MakeFooter(),
+	}
+	if results.Page > 1 {
+		data["prevURL"] = searchPageURL(c, results.Page-1)
+	}
+	if int64(results.Page*results.PerPage) < results.Total {
+		data["nextURL"] = searchPageURL(c, results.Page+1)
+	}
+	return c.Render(http.StatusOK, "search.html", data)
+}
+
+// GET /api/v1/search
+func apiSearchHandler(c echo.Context) error {
+	q, err := parseSearchQuery(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	results, err := search.Search(c.Get("user_id").(uint), q)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"originals": results.Originals,
+		"total":     results.Total,
+		"page":      results.Page,
+		"per_page":  results.PerPage,
+		"full_text": search.FullText(),
+	})
+}
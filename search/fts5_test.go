@@ -0,0 +1,129 @@
+//go:build sqlite_fts5
+
+package search
+
+import (
+	"slices"
+	"testing"
+
+	"ytdlp-site/database"
+	"ytdlp-site/originals"
+)
+
+// set up the library of setupLibrary with every original indexed
+func setupIndex(t *testing.T) {
+	t.Helper()
+	setupLibrary(t)
+	if !Setup() || !FullText() {
+		t.Fatal("no full-text index with the sqlite_fts5 tag")
+	}
+	if n, err := Backfill(); err != nil {
+		t.Fatal(err)
+	} else if n != 5 {
+		t.Fatalf("backfilled %d originals, want 5", n)
+	}
+}
+
+func TestSearchFullText(t *testing.T) {
+	setupIndex(t)
+
+	tests := []struct {
+		text string
+		want []string // in any order
+	}{
+		{"cat", []string{"Cat videos", "Dog park"}},
+		{"kitten", []string{"Cat videos"}},
+		{"kit", []string{"Cat videos"}}, // words match as prefixes
+		{"chef", []string{"Cooking"}},
+		{"small cat", []string{"Dog park"}},
+		{"bread", []string{"Cooking"}},
+		{"BAKE", []string{"Cooking"}},
+		{"bird", nil},
+		{`"cat`, []string{"Cat videos", "Dog park"}}, // not FTS5 syntax
+		{"cat OR bread", nil},
+		{"title:cat", nil},
+	}
+	for _, tc := range tests {
+		results, err := Search(alice, Query{Text: tc.text})
+		if err != nil {
+			t.Fatalf("%q: %v", tc.text, err)
+		}
+		got := titles(results)
+		slices.Sort(got)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%q: got %q, want %q", tc.text, got, tc.want)
+		}
+		if results.Total != int64(len(tc.want)) {
+			t.Errorf("%q: total %d, want %d", tc.text, results.Total, len(tc.want))
+		}
+	}
+}
+
+func TestSearchFullTextRanking(t *testing.T) {
+	setupIndex(t)
+
+	// the title match ranks above a mention in a longer description
+	results, err := Search(alice, Query{Text: "cat"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := titles(results); len(got) != 2 || got[0] != "Cat videos" {
+		t.Errorf("got %q, want Cat videos first", got)
+	}
+}
+
+func TestSearchFullTextPages(t *testing.T) {
+	setupIndex(t)
+	const carol = 3
+	addEpisodes(t, carol, 5)
+
+	var seen []string
+	for page := 1; page <= 3; page++ {
+		results, err := Search(carol, Query{Text: "episode", Page: page, PerPage: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if results.Total != 5 {
+			t.Errorf("page %d: total %d, want 5", page, results.Total)
+		}
+		seen = append(seen, titles(results)...)
+	}
+	slices.Sort(seen)
+	if want := []string{"Episode 1", "Episode 2", "Episode 3", "Episode 4", "Episode 5"}; !slices.Equal(seen, want) {
+		t.Errorf("pages held %q, want %q", seen, want)
+	}
+}
+
+func TestIndexAndRemove(t *testing.T) {
+	setupIndex(t)
+	db := database.Get()
+
+	var orig originals.Original
+	db.Where("title = ?", "Cat videos").First(&orig)
+	db.Model(&orig).Update("title", "Lion videos")
+	if err := Index(orig.ID); err != nil {
+		t.Fatal(err)
+	}
+	for text, want := range map[string][]string{
+		"lion":   {"Lion videos"},
+		"kitten": {"Lion videos"},
+		"cat":    {"Dog park"},
+	} {
+		results, err := Search(alice, Query{Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := titles(results); !slices.Equal(got, want) {
+			t.Errorf("after reindexing, %q: got %q, want %q", text, got, want)
+		}
+	}
+
+	if err := Remove(orig.ID); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := Search(alice, Query{Text: "lion"}); err != nil {
+		t.Fatal(err)
+	} else if len(results.Originals) != 0 {
+		t.Errorf("removed original still found: %q", titles(results))
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !sqlite_fts5
+
+package search
+
+import "testing"
+
+func TestSetupWithoutFTS5(t *testing.T) {
+	setupLibrary(t)
+	if Setup() || FullText() {
+		t.Error("full-text index without the sqlite_fts5 tag")
+	}
+}
@@ -0,0 +1,241 @@
+// Package search finds a user's originals by their title, artist,
+// description and subtitle text.
+//
+// It uses an SQLite FTS5 index when the SQLite driver was built with FTS5
+// (go build -tags sqlite_fts5), and falls back to LIKE matching of titles,
+// artists and descriptions otherwise.
+package search
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"ytdlp-site/config"
+	"ytdlp-site/database"
+	"ytdlp-site/media"
+	"ytdlp-site/originals"
+)
+
+const (
+	DefaultPerPage = 50
+	MaxPerPage     = 200
+)
+
+// the FTS5 table, whose rowid is the Original.ID
+const ftsTable = "originals_fts"
+
+var fts bool
+
+// Setup creates the full-text index if FTS5 is available, and reports whether it is
+func Setup() bool {
+	// quietly, the error is expected without FTS5
+	db := database.Get().Session(&gorm.Session{Logger: logger.Default.LogMode(logger.Silent)})
+	err := db.Exec("CREATE VIRTUAL TABLE IF NOT EXISTS " + ftsTable +
+		" USING fts5(title, artist, description, subtitles, tokenize = 'unicode61 remove_diacritics 2')").Error
+	fts = err == nil
+	return fts
+}
+
+// FullText reports whether searches use the full-text index
+func FullText() bool {
+	return fts
+}
+
+var vttTag = regexp.MustCompile(`<[^>]*>`)
+
+// the spoken text of a WebVTT file, without headers, cue timings or markup
+func subtitleText(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	var lines []string
+	var prev string
+	inCue := false // text only follows a cue timing line, up to the next blank line
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			inCue = false
+			continue
+		case strings.Contains(line, "-->"):
+			inCue = true
+			continue
+		case !inCue:
+			// the header, NOTE, STYLE and REGION blocks, and cue identifiers
+			continue
+		}
+		line = strings.TrimSpace(vttTag.ReplaceAllString(line, ""))
+		// automatic captions repeat each line as the next one scrolls in
+		if line != "" && line != prev {
+			lines = append(lines, line)
+			prev = line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Index adds or refreshes an original in the full-text index
+func Index(originalID uint) error {
+	if !fts {
+		return nil
+	}
+	db := database.Get()
+	var orig originals.Original
+	if err := db.First(&orig, originalID).Error; err != nil {
+		return err
+	}
+	var subtitles []media.Subtitle
+	db.Where("original_id = ?", originalID).Find(&subtitles)
+	var text []string
+	for _, s := range subtitles {
+		text = append(text, subtitleText(filepath.Join(config.GetDataDir(), s.Filename)))
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM "+ftsTable+" WHERE rowid = ?", originalID).Error; err != nil {
+			return err
+		}
+		return tx.Exec("INSERT INTO "+ftsTable+" (rowid, title, artist, description, subtitles) VALUES (?, ?, ?, ?, ?)",
+			orig.ID, orig.Title, orig.Artist, orig.Description, strings.Join(text, "\n")).Error
+	})
+}
+
+// Remove drops an original from the full-text index
+func Remove(originalID uint) error {
+	if !fts {
+		return nil
+	}
+	return database.Get().Exec("DELETE FROM "+ftsTable+" WHERE rowid = ?", originalID).Error
+}
+
+// Backfill indexes originals that aren't in the full-text index yet, and
+// drops entries for originals that are gone
+func Backfill() (int, error) {
+	if !fts {
+		return 0, nil
+	}
+	db := database.Get()
+	err := db.Exec("DELETE FROM " + ftsTable + " WHERE rowid NOT IN (SELECT id FROM originals WHERE deleted_at IS NULL)").Error
+	if err != nil {
+		return 0, err
+	}
+	var ids []uint
+	err = db.Model(&originals.Original{}).
+		Where("id NOT IN (SELECT rowid FROM "+ftsTable+")").
+		Pluck("id", &ids).Error
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		if err := Index(id); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// Query is a search of one user's originals. Zero values don't filter
+type Query struct {
+	Text       string
+	Status     originals.Status
+	Watched    *bool
+	Kind       string // "audio" or "video"
+	PlaylistID uint
+	From       time.Time // created at or after
+	To         time.Time // created before
+	Page       int       // from 1
+	PerPage    int
+}
+
+type Results struct {
+	Originals []originals.Original
+	Total     int64
+	Page      int
+	PerPage   int
+}
+
+// an FTS5 query matching every word of text as a prefix, so user input
+// can't be misread as FTS5 syntax
+func matchQuery(text string) string {
+	var terms []string
+	for _, word := range strings.Fields(text) {
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// LIKE pattern for a word, with the LIKE wildcards escaped
+func likePattern(word string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + r.Replace(word) + "%"
+}
+
+// Search returns a page of a user's originals that match q, best matches
+// first when there is search text and newest first otherwise
+func Search(userID uint, q Query) (Results, error) {
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.PerPage < 1 {
+		q.PerPage = DefaultPerPage
+	}
+	q.PerPage = min(q.PerPage, MaxPerPage)
+	results := Results{Originals: []originals.Original{}, Page: q.Page, PerPage: q.PerPage}
+
+	db := database.Get()
+	tx := db.Model(&originals.Original{}).Where("originals.user_id = ?", userID)
+	if q.Status != "" {
+		tx = tx.Where("originals.status = ?", q.Status)
+	}
+	if q.Watched != nil {
+		tx = tx.Where("originals.watched = ?", *q.Watched)
+	}
+	switch q.Kind {
+	case "audio":
+		tx = tx.Where("originals.audio = ?", true)
+	case "video":
+		tx = tx.Where("originals.video = ?", true)
+	}
+	if q.PlaylistID != 0 {
+		tx = tx.Where("originals.playlist = ? AND originals.playlist_id = ?", true, q.PlaylistID)
+	}
+	if !q.From.IsZero() {
+		tx = tx.Where("originals.created_at >= ?", q.From)
+	}
+	if !q.To.IsZero() {
+		tx = tx.Where("originals.created_at < ?", q.To)
+	}
+
+	order := "originals.id DESC"
+	if text := strings.TrimSpace(q.Text); text != "" && fts {
+		tx = tx.Joins("JOIN "+ftsTable+" ON "+ftsTable+".rowid = originals.id").
+			Where(ftsTable+" MATCH ?", matchQuery(text))
+		order = "bm25(" + ftsTable + "), originals.id DESC"
+	} else if text != "" {
+		for _, word := range strings.Fields(text) {
+			pattern := likePattern(word)
+			tx = tx.Where(`(originals.title LIKE ? ESCAPE '\' OR originals.artist LIKE ? ESCAPE '\' OR originals.description LIKE ? ESCAPE '\')`,
+				pattern, pattern, pattern)
+		}
+	}
+
+	tx = tx.Session(&gorm.Session{}) // reused for the count and the page
+	if err := tx.Count(&results.Total).Error; err != nil {
+		return results, err
+	}
+	err := tx.Select("originals.*").Order(order).
+		Offset((q.Page - 1) * q.PerPage).Limit(q.PerPage).
+		Find(&results.Originals).Error
+	return results, err
+}
@@ -0,0 +1,222 @@
+package search
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"ytdlp-site/database"
+	"ytdlp-site/database/dbtest"
+	"ytdlp-site/media"
+	"ytdlp-site/originals"
+)
+
+const alice, bob = 1, 2
+
+// set up a database holding alice's originals, oldest first, and one of bob's.
+// Only the cooking video has subtitles
+func setupLibrary(t *testing.T) {
+	t.Helper()
+	t.Setenv("YTDLP_SITE_DATA_DIR", t.TempDir())
+	db := dbtest.Open(t, &originals.Original{}, &media.Subtitle{})
+	t.Cleanup(func() { fts = false })
+
+	for _, orig := range []originals.Original{
+		{UserID: alice, Title: "Cat videos", Artist: "Kitten Channel"},
+		{UserID: alice, Title: "Dog park", Description: "a big dog and a small cat"},
+		{UserID: alice, Title: "Cooking", Artist: "Chef"},
+		{UserID: alice, Title: "100% snake_case"},
+		{UserID: bob, Title: "Cat facts"},
+	} {
+		if err := db.Create(&orig).Error; err != nil {
+			t.Fatal(err)
+		}
+		if orig.Title != "Cooking" {
+			continue
+		}
+		subtitle := media.Subtitle{OriginalID: orig.ID, Lang: "en"}
+		subtitle.Filename = "cooking.vtt"
+		vtt := "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\ntoday we bake bread\n"
+		if err := os.WriteFile(filepath.Join(os.Getenv("YTDLP_SITE_DATA_DIR"), subtitle.Filename), []byte(vtt), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Create(&subtitle).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// add n originals titled "Episode 1" to "Episode n" for user
+func addEpisodes(t *testing.T, user uint, n int) {
+	t.Helper()
+	for i := 1; i <= n; i++ {
+		orig := originals.Original{UserID: user, Title: fmt.Sprintf("Episode %d", i)}
+		if err := database.Get().Create(&orig).Error; err != nil {
+			t.Fatal(err)
+		}
+		if err := Index(orig.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func titles(results Results) []string {
+	var titles []string
+	for _, orig := range results.Originals {
+		titles = append(titles, orig.Title)
+	}
+	return titles
+}
+
+func TestSearchLike(t *testing.T) {
+	setupLibrary(t)
+
+	tests := []struct {
+		text string
+		want []string // newest first
+	}{
+		{"", []string{"100% snake_case", "Cooking", "Dog park", "Cat videos"}},
+		{"cat", []string{"Dog park", "Cat videos"}},
+		{"kitten", []string{"Cat videos"}},
+		{"BIG dog", []string{"Dog park"}},
+		{"cat dog", []string{"Dog park"}},
+		{"bread", nil}, // subtitles need the full-text index
+		{"%", []string{"100% snake_case"}},
+		{"e_c", []string{"100% snake_case"}},
+		{"bird", nil},
+	}
+	for _, tc := range tests {
+		results, err := Search(alice, Query{Text: tc.text})
+		if err != nil {
+			t.Fatalf("%q: %v", tc.text, err)
+		}
+		if got := titles(results); !slices.Equal(got, tc.want) {
+			t.Errorf("%q: got %q, want %q", tc.text, got, tc.want)
+		}
+		if results.Total != int64(len(tc.want)) {
+			t.Errorf("%q: total %d, want %d", tc.text, results.Total, len(tc.want))
+		}
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	setupLibrary(t)
+	const carol = 3
+	addEpisodes(t, carol, 5)
+
+	tests := []struct {
+		page, perPage int
+		want          []string
+		wantPage      int
+		wantPerPage   int
+	}{
+		{1, 2, []string{"Episode 5", "Episode 4"}, 1, 2},
+		{2, 2, []string{"Episode 3", "Episode 2"}, 2, 2},
+		{3, 2, []string{"Episode 1"}, 3, 2},
+		{4, 2, nil, 4, 2},
+		{0, 2, []string{"Episode 5", "Episode 4"}, 1, 2},
+		{1, 0, []string{"Episode 5", "Episode 4", "Episode 3", "Episode 2", "Episode 1"}, 1, DefaultPerPage},
+		{1, MaxPerPage + 1, []string{"Episode 5", "Episode 4", "Episode 3", "Episode 2", "Episode 1"}, 1, MaxPerPage},
+	}
+	for _, tc := range tests {
+		results, err := Search(carol, Query{Page: tc.page, PerPage: tc.perPage})
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := fmt.Sprintf("page %d of %d", tc.page, tc.perPage)
+		if got := titles(results); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", name, got, tc.want)
+		}
+		if results.Total != 5 {
+			t.Errorf("%s: total %d, want 5", name, results.Total)
+		}
+		if results.Page != tc.wantPage || results.PerPage != tc.wantPerPage {
+			t.Errorf("%s: page %d of %d, want %d of %d", name, results.Page, results.PerPage, tc.wantPage, tc.wantPerPage)
+		}
+	}
+}
+
+func TestMatchQuery(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"cat", `"cat"*`},
+		{" big  cat ", `"big"* "cat"*`},
+		{`say "hi"`, `"say"* """hi"""*`},
+		{"title:cat OR dog*", `"title:cat"* "OR"* "dog*"*`},
+		{"NEAR(a b)", `"NEAR(a"* "b)"*`},
+	}
+	for _, tc := range tests {
+		if got := matchQuery(tc.text); got != tc.want {
+			t.Errorf("matchQuery(%q) = %s, want %s", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestLikePattern(t *testing.T) {
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"cat", `%cat%`},
+		{"100%", `%100\%%`},
+		{"snake_case", `%snake\_case%`},
+		{`back\slash`, `%back\\slash%`},
+		{`\%_`, `%\\\%\_%`},
+	}
+	for _, tc := range tests {
+		if got := likePattern(tc.word); got != tc.want {
+			t.Errorf("likePattern(%q) = %s, want %s", tc.word, got, tc.want)
+		}
+	}
+}
+
+func TestSubtitleText(t *testing.T) {
+	tests := []struct {
+		name string
+		vtt  string
+		want string
+	}{
+		{"plain cues",
+			"WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHello there\n\n00:00:02.000 --> 00:00:03.000\nGeneral Kenobi\n",
+			"Hello there\nGeneral Kenobi"},
+		{"header metadata, notes, styles, regions and cue identifiers",
+			"WEBVTT - title\nKind: captions\nLanguage: en\n\n" +
+				"NOTE a comment\nspanning lines\n\n" +
+				"STYLE\n::cue { color: red }\n\n" +
+				"REGION\nid:fred\n\n" +
+				"1\n00:00:01.000 --> 00:00:02.000 align:start\nFirst line\nsecond line\n\n" +
+				"intro-2\n00:00:02.000 --> 00:00:03.000\nThird\n",
+			"First line\nsecond line\nThird"},
+		{"markup",
+			"WEBVTT\n\n00:00:01.000 --> 00:00:02.000\n<v Roger>Hi <b>there</b></v>\n<00:00:01.500><c>word</c>\n",
+			"Hi there\nword"},
+		{"scrolling automatic captions",
+			"WEBVTT\n\n00:00:01.000 --> 00:00:02.000\none\n\n00:00:02.000 --> 00:00:03.000\none\ntwo\n\n00:00:03.000 --> 00:00:04.000\ntwo\nthree\n",
+			"one\ntwo\nthree"},
+		{"windows line endings",
+			"WEBVTT\r\n\r\n00:00:01.000 --> 00:00:02.000\r\nHello\r\n",
+			"Hello"},
+		{"empty", "WEBVTT\n", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sub.vtt")
+			if err := os.WriteFile(path, []byte(tc.vtt), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if got := subtitleText(path); got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+
+	if got := subtitleText(filepath.Join(t.TempDir(), "missing.vtt")); got != "" {
+		t.Errorf("got %q for a missing file", got)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"ytdlp-site/originals"
+)
+
+func TestSearchByStatus(t *testing.T) {
+	e := setupTestServer(t)
+	alice := createTestUser(t, "alice")
+	db.Model(&alice.original).Update("status", originals.StatusDownloadCompleted)
+
+	rec := serve(e, alice, http.MethodGet, "/search")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	for _, status := range originals.Statuses {
+		if !strings.Contains(rec.Body.String(), `<option value="`+string(status)+`"`) {
+			t.Errorf("no %q option", status)
+		}
+	}
+
+	for status, found := range map[originals.Status]bool{
+		originals.StatusDownloadCompleted: true,
+		originals.StatusCompleted:         false,
+	} {
+		rec := serve(e, alice, http.MethodGet, "/search?status="+url.QueryEscape(string(status)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", status, rec.Code)
+		}
+		if got := strings.Contains(html.UnescapeString(rec.Body.String()), alice.original.Title); got != found {
+			t.Errorf("%s: found the original %t, want %t", status, got, found)
+		}
+	}
+
+	if rec := serve(e, alice, http.MethodGet, "/search?status=bogus"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown status: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,22 @@
+// Seek to a chapter in whichever video or audio was played last
+let lastPlayed = null;
+
+document.querySelectorAll('video, audio').forEach(media => {
+    media.addEventListener('play', () => {
+        if (!media.closest('.clips')) {
+            lastPlayed = media;
+        }
+    });
+});
+
+document.querySelectorAll('.chapter-link').forEach(link => {
+    link.addEventListener('click', event => {
+        const media = lastPlayed || document.querySelector('.media-grid:not(.clips) video, .media-grid:not(.clips) audio');
+        if (!media) {
+            return;
+        }
+        event.preventDefault();
+        media.currentTime = parseFloat(link.dataset.start);
+        media.play();
+    });
+});
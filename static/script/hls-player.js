@@ -0,0 +1,283 @@
+// Plays the site's HLS packages through Media Source Extensions, for
+// browsers without native HLS. It only handles what the site packages: a
+// master playlist of fMP4 VOD variants with CODECS, each with an init
+// segment. Variants are switched at segment boundaries by measured
+// throughput.
+
+const hlsBufferAhead = 30; // seconds to buffer past the playhead
+const hlsKeepBehind = 60; // seconds of played media to keep buffered
+
+// KEY=VALUE,KEY="quoted, value" attribute lists
+function hlsParseAttributes(text) {
+    const attrs = {};
+    const re = /([A-Z0-9-]+)=("[^"]*"|[^,]*)/g;
+    let m;
+    while ((m = re.exec(text)) !== null) {
+        attrs[m[1]] = m[2].replace(/^"|"$/g, '');
+    }
+    return attrs;
+}
+
+function hlsParseMaster(text, base) {
+    const variants = [];
+    let attrs = null;
+    for (const raw of text.split('\n')) {
+        const line = raw.trim();
+        if (line.startsWith('#EXT-X-STREAM-INF:')) {
+            attrs = hlsParseAttributes(line.slice('#EXT-X-STREAM-INF:'.length));
+        } else if (line && !line.startsWith('#') && attrs) {
+            variants.push({
+                bandwidth: parseInt(attrs['BANDWIDTH'], 10) || 0,
+                codecs: attrs['CODECS'] || '',
+                url: new URL(line, base).href,
+            });
+            attrs = null;
+        }
+    }
+    return variants;
+}
+
+function hlsParseMedia(text, base) {
+    const playlist = { init: null, segments: [], duration: 0 };
+    let duration = null;
+    for (const raw of text.split('\n')) {
+        const line = raw.trim();
+        if (line.startsWith('#EXT-X-MAP:')) {
+            const uri = hlsParseAttributes(line.slice('#EXT-X-MAP:'.length))['URI'];
+            playlist.init = new URL(uri, base).href;
+        } else if (line.startsWith('#EXTINF:')) {
+            duration = parseFloat(line.slice('#EXTINF:'.length));
+        } else if (line && !line.startsWith('#') && duration !== null) {
+            playlist.segments.push({ url: new URL(line, base).href, start: playlist.duration, duration: duration });
+            playlist.duration += duration;
+            duration = null;
+        }
+    }
+    return playlist;
+}
+
+function hlsMimeType(variant) {
+    return `video/mp4; codecs="${variant.codecs}"`;
+}
+
+async function hlsFetch(url) {
+    const resp = await fetch(url, { credentials: 'same-origin' });
+    if (!resp.ok) {
+        throw new Error(`${url}: ${resp.status}`);
+    }
+    return resp;
+}
+
+class HlsPlayer {
+    constructor(video, url) {
+        this.video = video;
+        this.url = url;
+        this.variants = []; // playable ones, lowest bandwidth first
+        this.playlists = new Map(); // variant URL to media playlist promise
+        this.current = null; // the variant whose init segment was appended last
+        this.nextTime = 0; // where the next segment should start
+        this.bandwidth = 0; // measured bits per second
+        this.started = false; // whether segments may be fetched, as with preload="none"
+        this.busy = false;
+
+        this.mediaSource = new MediaSource();
+        this.mediaSource.addEventListener('sourceopen', () => this.open(), { once: true });
+        video.src = URL.createObjectURL(this.mediaSource);
+
+        video.addEventListener('play', () => this.start());
+        video.addEventListener('seeking', () => this.seek());
+        video.addEventListener('timeupdate', () => this.fill());
+        video.addEventListener('waiting', () => this.skipGap());
+    }
+
+    async open() {
+        URL.revokeObjectURL(this.video.src);
+        try {
+            const resp = await hlsFetch(this.url);
+            const canSwitch = 'changeType' in SourceBuffer.prototype;
+            const variants = hlsParseMaster(await resp.text(), this.url)
+                .filter(v => v.codecs && MediaSource.isTypeSupported(hlsMimeType(v)))
+                .sort((a, b) => a.bandwidth - b.bandwidth);
+            // without changeType, stay with the codecs of the first variant
+            this.variants = variants.filter(v => canSwitch || v.codecs == variants[0].codecs);
+            if (this.variants.length == 0) {
+                throw new Error('no playable variants');
+            }
+            const playlist = await this.playlist(this.variants[0]);
+            this.mediaSource.duration = playlist.duration;
+            this.sourceBuffer = this.mediaSource.addSourceBuffer(hlsMimeType(this.variants[0]));
+        } catch (error) {
+            console.error('HLS unavailable, using the other sources:', error);
+            this.video.removeAttribute('src');
+            this.video.load();
+            return;
+        }
+        this.seek();
+    }
+
+    playlist(variant) {
+        if (!this.playlists.has(variant.url)) {
+            const playlist = hlsFetch(variant.url)
+                .then(resp => resp.text())
+                .then(text => hlsParseMedia(text, variant.url));
+            // try again next time if it failed
+            playlist.catch(() => this.playlists.delete(variant.url));
+            this.playlists.set(variant.url, playlist);
+        }
+        return this.playlists.get(variant.url);
+    }
+
+    // the highest bandwidth variant the measured throughput can keep up with
+    pick() {
+        let choice = this.variants[0];
+        for (const variant of this.variants) {
+            if (variant.bandwidth <= 0.8 * this.bandwidth) {
+                choice = variant;
+            }
+        }
+        return choice;
+    }
+
+    // the end of the buffered range at time, or time if it isn't buffered
+    bufferedEnd(time) {
+        const buffered = this.video.buffered;
+        for (let i = 0; i < buffered.length; i++) {
+            if (buffered.start(i) <= time + 0.1 && time < buffered.end(i)) {
+                return buffered.end(i);
+            }
+        }
+        return time;
+    }
+
+    start() {
+        this.started = true;
+        this.fill();
+    }
+
+    seek() {
+        if (this.video.seeking) {
+            this.started = true;
+        }
+        this.nextTime = this.bufferedEnd(this.video.currentTime);
+        this.fill();
+    }
+
+    // jump small gaps, like one before the first frame
+    skipGap() {
+        const buffered = this.video.buffered;
+        const time = this.video.currentTime;
+        for (let i = 0; i < buffered.length; i++) {
+            if (buffered.start(i) <= time && time < buffered.end(i)) {
+                return;
+            }
+            if (buffered.start(i) > time && buffered.start(i) - time < 1) {
+                this.video.currentTime = buffered.start(i);
+                return;
+            }
+        }
+    }
+
+    async fetchSegment(url) {
+        const started = performance.now();
+        const data = await (await hlsFetch(url)).arrayBuffer();
+        const seconds = (performance.now() - started) / 1000;
+        if (seconds > 0) {
+            const sample = data.byteLength * 8 / seconds;
+            this.bandwidth = this.bandwidth ? 0.7 * this.bandwidth + 0.3 * sample : sample;
+        }
+        return data;
+    }
+
+    // wait for the source buffer to finish an append or remove
+    update(action) {
+        return new Promise((resolve, reject) => {
+            const done = () => {
+                this.sourceBuffer.removeEventListener('updateend', done);
+                this.sourceBuffer.removeEventListener('error', failed);
+                resolve();
+            };
+            const failed = () => {
+                this.sourceBuffer.removeEventListener('updateend', done);
+                this.sourceBuffer.removeEventListener('error', failed);
+                reject(new Error('source buffer error'));
+            };
+            this.sourceBuffer.addEventListener('updateend', done);
+            this.sourceBuffer.addEventListener('error', failed);
+            try {
+                action();
+            } catch (error) {
+                this.sourceBuffer.removeEventListener('updateend', done);
+                this.sourceBuffer.removeEventListener('error', failed);
+                reject(error);
+            }
+        });
+    }
+
+    async append(data) {
+        try {
+            await this.update(() => this.sourceBuffer.appendBuffer(data));
+        } catch (error) {
+            if (error.name != 'QuotaExceededError') {
+                throw error;
+            }
+            // out of room: drop everything before the playhead and retry
+            await this.trim(0);
+            await this.update(() => this.sourceBuffer.appendBuffer(data));
+        }
+    }
+
+    // remove media that played more than keep seconds ago
+    async trim(keep) {
+        const buffered = this.sourceBuffer.buffered;
+        const end = this.video.currentTime - keep;
+        if (buffered.length > 0 && buffered.start(0) < end - 1) {
+            await this.update(() => this.sourceBuffer.remove(buffered.start(0), end));
+        }
+    }
+
+    async fill() {
+        if (!this.sourceBuffer || !this.started || this.busy) {
+            return;
+        }
+        this.busy = true;
+        try {
+            while (this.nextTime - this.video.currentTime < hlsBufferAhead) {
+                const variant = this.pick();
+                const playlist = await this.playlist(variant);
+                const from = this.nextTime;
+                const segment = playlist.segments.find(s => from < s.start + s.duration - 0.05);
+                if (!segment) {
+                    if (this.mediaSource.readyState == 'open') {
+                        this.mediaSource.endOfStream();
+                    }
+                    break;
+                }
+
+                if (variant != this.current) {
+                    if (this.current && this.current.codecs != variant.codecs) {
+                        this.sourceBuffer.changeType(hlsMimeType(variant));
+                    }
+                    await this.append(await (await hlsFetch(playlist.init)).arrayBuffer());
+                    this.current = variant;
+                }
+                await this.append(await this.fetchSegment(segment.url));
+                // unless a seek moved on meanwhile
+                if (this.nextTime == from) {
+                    this.nextTime = segment.start + segment.duration;
+                }
+                await this.trim(hlsKeepBehind);
+                this.skipGap();
+            }
+        } catch (error) {
+            console.error('HLS playback failed:', error);
+        } finally {
+            this.busy = false;
+        }
+    }
+}
+
+if (window.MediaSource && !document.createElement('video').canPlayType('application/vnd.apple.mpegurl')) {
+    document.querySelectorAll('video[data-hls]').forEach(video => {
+        new HlsPlayer(video, new URL(video.dataset.hls, window.location.href).href);
+    });
+}
@@ -0,0 +1,18 @@
+// Human-readable sizes and durations, shared by the progress scripts
+
+function humanBytes(bytes) {
+    const units = ["bytes", "KiB", "MiB", "GiB"];
+    let i = 0;
+    while (bytes >= 1024 && i < units.length - 1) {
+        bytes /= 1024;
+        i++;
+    }
+    return i == 0 ? `${bytes} ${units[i]}` : `${bytes.toFixed(1)} ${units[i]}`;
+}
+
+function humanSeconds(secs) {
+    const hh = Math.floor(secs / 3600);
+    const mm = Math.floor((secs % 3600) / 60);
+    const ss = Math.floor(secs % 60);
+    return `${hh}:${String(mm).padStart(2, '0')}:${String(ss).padStart(2, '0')}`;
+}
@@ -0,0 +1,37 @@
+const transcodesDiv = document.querySelector('.transcodes');
+
+function updateTranscode(tp) {
+    const div = document.getElementById(`transcode-${tp.ID}`);
+    if (!div) {
+        console.error(`Transcode not found for ID ${tp.ID}`);
+        return;
+    }
+
+    div.querySelector('progress').value = tp.Percent;
+    div.querySelector('.transcode-status').textContent = tp.Percent >= 100 ? "done" : "running";
+
+    let text = `${tp.Percent.toFixed(1)}%`;
+    if (tp.ETA >= 0 && tp.Percent < 100) {
+        text += `, ETA ${humanSeconds(tp.ETA)}`;
+    }
+    div.querySelector('.transcode-progress-text').textContent = text;
+}
+
+if (transcodesDiv) {
+    const originalId = parseInt(transcodesDiv.dataset.originalId);
+    const eventSource = new EventSource('/videos/events');
+
+    eventSource.onmessage = function (event) {
+        const data = JSON.parse(event.data);
+        if (data.VideoId == originalId && data.Transcode) {
+            updateTranscode(data.Transcode);
+        }
+    };
+
+    eventSource.onerror = function (error) {
+        console.error('EventSource failed:', error);
+        eventSource.close();
+    };
+
+    window.addEventListener('beforeunload', () => eventSource.close());
+}
@@ -59,28 +59,67 @@ function updateCardsStyling(card) {
             hideDivs(card, false, [".video-title-bare"])
         }
 
-        showDivs(card, (statusText == "completed"), [".reprocess-btn", ".delete-btn"])
-        showDivs(card, (statusText == "failed"), [".restart-btn"])
+        const stopped = ["failed", "cancelled", "quota exceeded"].includes(statusText);
+        showDivs(card, (statusText == "completed"), [".reprocess-btn"])
+        showDivs(card, (statusText == "completed" || stopped), [".delete-btn"])
+        showDivs(card, stopped, [".restart-btn"])
+        showDivs(card, !(statusText == "completed" || stopped), [".cancel-btn"])
 
     }
 }
 
+function updateProgress(card, data) {
+    const progressDiv = card.querySelector('.video-progress');
+    if (!progressDiv) {
+        return;
+    }
+
+    // only show progress while downloading
+    if (!data.Progress || data.Status != "downloading") {
+        progressDiv.classList.add("hidden");
+        return;
+    }
+    progressDiv.classList.remove("hidden");
+
+    const p = data.Progress;
+    const bar = progressDiv.querySelector('progress');
+    if (p.Percent >= 0) {
+        bar.value = p.Percent;
+    } else {
+        bar.removeAttribute('value'); // indeterminate
+    }
+
+    let text = humanBytes(p.Bytes);
+    if (p.Total > 0) {
+        text += ` / ${humanBytes(p.Total)}`;
+    }
+    if (p.Speed > 0) {
+        text += `, ${humanBytes(p.Speed)}/s`;
+    }
+    if (p.ETA >= 0) {
+        text += `, ETA ${humanSeconds(p.ETA)}`;
+    }
+    progressDiv.querySelector('.video-progress-text').textContent = text;
+}
+
 eventSource.onmessage = function (event) {
     const data = JSON.parse(event.data);
     console.log(data)
 
     const videoCard = document.getElementById(`video-card-${data.VideoId}`);
-    if (videoCard) {
-        const statusDiv = videoCard.querySelector('.video-info.video-status');
-        if (statusDiv) {
-            statusDiv.textContent = data.Status;
-        } else {
-            console.error(`Status div not found for video ID ${data.VideoId}`);
-        }
-    } else {
+    if (!videoCard) {
         console.error(`Video card not found for ID ${data.VideoId}`);
+        return;
+    }
+
+    const statusDiv = videoCard.querySelector('.video-info.video-status');
+    if (statusDiv) {
+        statusDiv.textContent = data.Status;
+    } else {
+        console.error(`Status div not found for video ID ${data.VideoId}`);
     }
 
+    updateProgress(videoCard, data)
     updateCardsStyling(videoCard)
 };
 
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"ytdlp-site/config"
+	"ytdlp-site/ffmpeg"
+	"ytdlp-site/media"
+)
+
+// yt-dlp --sub-langs values: comma-separated language codes or regexes like "en.*",
+// "all", and exclusions like "-live_chat" after the first
+var subLangsRegexp = regexp.MustCompile(`^[A-Za-z0-9_.*][A-Za-z0-9_.*-]*(,-?[A-Za-z0-9_.*][A-Za-z0-9_.*-]*)*$`)
+
+// subtitles are downloaded alongside the media as "subs/sub.<lang>.<ext>"
+const subtitleDir = "subs"
+const subtitleOutput = "subtitle:" + subtitleDir + "/sub.%(ext)s"
+
+// parse requested subtitle languages, "" if none were requested
+func parseSubLangs(s string) (string, error) {
+	var langs []string
+	for _, lang := range strings.Split(s, ",") {
+		if lang = strings.TrimSpace(lang); lang != "" {
+			langs = append(langs, lang)
+		}
+	}
+	joined := strings.Join(langs, ",")
+	if joined != "" && !subLangsRegexp.MatchString(joined) {
+		return "", fmt.Errorf("invalid subtitle languages %q", s)
+	}
+	return joined, nil
+}
+
+// the language of a subtitle file downloaded as "sub.<lang>.<ext>"
+func subtitleLang(name string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(name, "sub."), filepath.Ext(name))
+}
+
+// yt-dlp arguments that download subtitles in langs as WebVTT with the media,
+// none if no languages were requested. Where a language has both, yt-dlp
+// prefers uploaded subtitles over automatic ones
+func subtitleArgs(langs string) []string {
+	if langs == "" {
+		return nil
+	}
+	return []string{"--write-subs", "--write-auto-subs", "--sub-langs", langs,
+		"--sub-format", "vtt/best", "--convert-subs", "vtt", "-o", subtitleOutput}
+}
+
+// record the subtitles downloaded into dir, replacing any the original had.
+// uploaded holds the languages whose subtitles aren't automatic. Failures
+// are only logged, subtitles are optional
+func saveSubtitles(originalID uint, dir string, uploaded map[string]bool) {
+	deleteSubtitles(originalID)
+	entries, _ := os.ReadDir(dir)
+	stored := 0
+	for _, entry := range entries {
+		lang := subtitleLang(entry.Name())
+		if entry.IsDir() || lang == "" {
+			continue
+		}
+		if err := storeSubtitle(originalID, filepath.Join(dir, entry.Name()), lang, !uploaded[lang]); err != nil {
+			log.Errorln("couldn't store", lang, "subtitles for original", originalID, err)
+			continue
+		}
+		stored++
+	}
+	log.Infof("downloaded %d subtitle tracks for original %d", stored, originalID)
+}
+
+// move a downloaded subtitle file into the data directory as WebVTT and record it
+func storeSubtitle(originalID uint, path, lang string, auto bool) error {
+	dstName := uuid.Must(uuid.NewV7()).String() + ".vtt"
+	dstPath := filepath.Join(config.GetDataDir(), dstName)
+	if strings.EqualFold(filepath.Ext(path), ".vtt") {
+		if err := os.Rename(path, dstPath); err != nil {
+			return err
+		}
+	} else if _, stderr, err := ffmpeg.Ffmpeg("-i", path, "-f", "webvtt", dstPath); err != nil {
+		os.Remove(dstPath)
+		return fmt.Errorf("convert to WebVTT: %w: %s", err, stderr)
+	}
+	size, err := getSize(dstPath)
+	if err != nil {
+		return err
+	}
+	subtitle := media.Subtitle{
+		MediaFile: media.MediaFile{
+			Size:     size,
+			Type:     media.SubtitleType,
+			Codec:    "webvtt",
+			Filename: dstName,
+		},
+		OriginalID: originalID,
+		Lang:       lang,
+		Auto:       auto,
+	}
+	if err := db.Create(&subtitle).Error; err != nil {
+		os.Remove(dstPath)
+		return err
+	}
+	return nil
+}
+
+// remove an original's subtitles and their files
+func deleteSubtitles(originalID uint) {
+	var subtitles []media.Subtitle
+	db.Where("original_id = ?", originalID).Find(&subtitles)
+	for _, s := range subtitles {
+		path := filepath.Join(config.GetDataDir(), s.Filename)
+		log.Debugln("remove subtitle", path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Errorln("error removing", path, err)
+		}
+	}
+	db.Delete(&media.Subtitle{}, "original_id = ?", originalID)
+}
+
+// a subtitle track as shown on the video page
+type SubtitleTemplate struct {
+	ID       uint // Subtitle.ID
+	Lang     string
+	Label    string
+	Filename string
+}
+
+func subtitleTemplates(originalID uint) []SubtitleTemplate {
+	var subtitles []media.Subtitle
+	db.Where("original_id = ?", originalID).Order("auto ASC, lang ASC").Find(&subtitles)
+	var display []SubtitleTemplate
+	for _, s := range subtitles {
+		label := s.Lang
+		if s.Auto {
+			label += " (auto)"
+		}
+		display = append(display, SubtitleTemplate{
+			ID:       s.ID,
+			Lang:     s.Lang,
+			Label:    label,
+			Filename: s.Filename,
+		})
+	}
+	return display
+}
+
+// GET /api/v1/originals/:id/subtitles
+func apiSubtitlesHandler(c echo.Context) error {
+	orig, err := apiOriginal(c)
+	if err != nil {
+		return err
+	}
+	subtitles := []media.Subtitle{}
+	if err := db.Where("original_id = ?", orig.ID).Find(&subtitles).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, subtitles)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ytdlp-site/media"
+)
+
+func TestParseSubLangs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"", "", true},
+		{" , ", "", true},
+		{"en", "en", true},
+		{"en, pt-BR ,de", "en,pt-BR,de", true},
+		{"en.*", "en.*", true},
+		{"all,-live_chat", "all,-live_chat", true},
+		{"en,", "en", true},
+		{"-live_chat", "", false}, // exclusions only after the first
+		{"en;rm -rf", "", false},
+		{"en fr", "", false},
+		{"--exec", "", false},
+	}
+	for _, tc := range tests {
+		got, err := parseSubLangs(tc.in)
+		if (err == nil) != tc.ok {
+			t.Errorf("parseSubLangs(%q) error = %v, want ok %t", tc.in, err, tc.ok)
+		} else if got != tc.want {
+			t.Errorf("parseSubLangs(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestSubtitleLang(t *testing.T) {
+	for name, want := range map[string]string{
+		"sub.en.vtt":         "en",
+		"sub.pt-BR.vtt":      "pt-BR",
+		"sub.en-en-US.vtt":   "en-en-US",
+		"sub.live_chat.json": "live_chat",
+		"sub.zh.Hans.vtt":    "zh.Hans",
+	} {
+		if got := subtitleLang(name); got != want {
+			t.Errorf("subtitleLang(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestSaveSubtitles(t *testing.T) {
+	setupTestServer(t)
+	alice := createTestUser(t, "alice")
+	dir := t.TempDir()
+	for _, name := range []string{"sub.en.vtt", "sub.de.vtt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("WEBVTT\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saveSubtitles(alice.original.ID, dir, map[string]bool{"en": true})
+
+	var subtitles []media.Subtitle
+	db.Where("original_id = ?", alice.original.ID).Order("lang").Find(&subtitles)
+	if len(subtitles) != 2 {
+		t.Fatalf("saved %d subtitles, want 2", len(subtitles))
+	}
+	for _, s := range subtitles {
+		if want := s.Lang != "en"; s.Auto != want {
+			t.Errorf("%s subtitles auto = %t, want %t", s.Lang, s.Auto, want)
+		}
+		if _, err := os.Stat(filepath.Join(os.Getenv("YTDLP_SITE_DATA_DIR"), s.Filename)); err != nil {
+			t.Errorf("%s subtitles: %v", s.Lang, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA1 key from RFC 6238 appendix B, "12345678901234567890"
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238(t *testing.T) {
+	// RFC 6238 appendix B gives 8 digit codes; 6 digit codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+	for _, tc := range tests {
+		got, err := Code(rfcSecret, time.Unix(tc.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("Code at %d = %s, want %s", tc.unix, got, tc.want)
+		}
+	}
+}
+
+func TestValidateWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+
+	tests := []struct {
+		name   string
+		offset int64 // steps from now
+		ok     bool
+	}{
+		{"two steps early", -2, false},
+		{"previous step", -1, true},
+		{"current step", 0, true},
+		{"next step", 1, true},
+		{"two steps late", 2, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			code, err := CodeAt(rfcSecret, step+tc.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := Validate(rfcSecret, code, now)
+			if ok != tc.ok {
+				t.Fatalf("Validate ok = %t, want %t", ok, tc.ok)
+			}
+			if ok && got != step+tc.offset {
+				t.Errorf("Validate step = %d, want %d", got, step+tc.offset)
+			}
+		})
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	tests := []struct {
+		code string
+		ok   bool
+	}{
+		{"050471", true},
+		{"050 471", true},
+		{"050472", false},
+		{"05047", false},
+		{"0504711", false},
+		{"", false},
+	}
+	for _, tc := range tests {
+		if _, ok := Validate(rfcSecret, tc.code, now); ok != tc.ok {
+			t.Errorf("Validate(%q) = %t, want %t", tc.code, ok, tc.ok)
+		}
+	}
+
+	// secrets are accepted in any case, with spaces, as apps display them
+	spaced := strings.ToLower(rfcSecret[:4] + " " + rfcSecret[4:])
+	if _, ok := Validate(spaced, "050471", now); !ok {
+		t.Error("Validate rejected a lowercase secret with spaces")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("ytdlp-site", "some user", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/ytdlp-site:some user" {
+		t.Errorf("unexpected URI %s", uri)
+	}
+	q := uri.Query()
+	if q.Get("secret") != rfcSecret || q.Get("issuer") != "ytdlp-site" || q.Get("digits") != "6" || q.Get("period") != "30" {
+		t.Errorf("unexpected query %s", uri.RawQuery)
+	}
+}
@@ -6,6 +6,13 @@ import (
 	"gorm.io/gorm"
 )
 
+// MaxConcurrent is how many ffmpeg encodes may run at once
+const MaxConcurrent = 2
+
+// Slots is shared by transcodes and re-encoded clips: send to take a slot,
+// receive to give it back
+var Slots = make(chan struct{}, MaxConcurrent)
+
 type Transcode struct {
 	gorm.Model
 	Status     string // "pending", "running", "failed"
@@ -15,12 +22,66 @@ type Transcode struct {
 	DstKind    string // "video", "audio"
 	TimeSubmit time.Time
 	TimeStart  time.Time
+	Progress   float64 // percent complete
 
 	// video fields
-	Height uint    // target height
-	Width  uint    // target width
-	FPS    float64 // target FPS
+	Height          uint    // target height
+	Width           uint    // target width
+	FPS             float64 // target FPS
+	VideoCodec      string
+	CRF             uint   // constant quality, used if VideoKbps is 0
+	VideoKbps       uint   // target video bitrate
+	Preset          string // encoder speed preset
+	KeyframeSeconds uint   // seconds between forced keyframes, 0 to leave them to the encoder
 
 	// audio & video fields
-	Kbps uint
+	Kbps       uint // target audio bitrate
+	AudioCodec string
+	Container  string
+}
+
+// ApplyDefaults fills in any encoding parameters that were not chosen,
+// matching what transcodes were before they were configurable
+func (t *Transcode) ApplyDefaults() {
+	if t.DstKind == "video" {
+		if t.VideoCodec == "" {
+			t.VideoCodec = "h264"
+		}
+		if t.CRF == 0 && t.VideoKbps == 0 {
+			t.CRF = 23
+		}
+		if t.Preset == "" {
+			t.Preset = "fast"
+		}
+		if t.Container == "" {
+			t.Container = "mp4"
+		}
+		if t.AudioCodec == "" {
+			t.AudioCodec = "aac"
+		}
+		if t.Kbps == 0 {
+			switch {
+			case t.Height <= 240:
+				t.Kbps = 64
+			case t.Height <= 540:
+				t.Kbps = 96
+			case t.Height < 720:
+				t.Kbps = 128
+			default:
+				t.Kbps = 160
+			}
+		}
+	} else if t.DstKind == "audio" {
+		if t.AudioCodec == "" {
+			t.AudioCodec = "mp3"
+		}
+		if t.Container == "" && t.AudioCodec == "aac" {
+			t.Container = "m4a"
+		} else if t.Container == "" {
+			t.Container = t.AudioCodec
+		}
+		if t.Kbps == 0 {
+			t.Kbps = 64
+		}
+	}
 }
@@ -0,0 +1,43 @@
+package users
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// feed keys are random, so a fast hash is enough, and lets them be looked up
+func hashFeedKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ResetFeedKey replaces the user's feed key, breaking any existing subscriptions.
+// The key is only stored hashed, so it can't be retrieved again later
+func ResetFeedKey(db *gorm.DB, userID uint) (string, error) {
+	key, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	if err := db.Model(&User{}).Where("id = ?", userID).Update("feed_key_hash", hashFeedKey(key)).Error; err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// LookupFeedKey returns the enabled user a feed key belongs to
+func LookupFeedKey(db *gorm.DB, key string) (User, error) {
+	var user User
+	if key == "" {
+		return user, fmt.Errorf("no feed key")
+	}
+	if err := db.Where("feed_key_hash = ?", hashFeedKey(key)).First(&user).Error; err != nil {
+		return user, err
+	}
+	if user.Disabled {
+		return user, fmt.Errorf("user %d is disabled", user.ID)
+	}
+	return user, nil
+}
@@ -0,0 +1,49 @@
+package users
+
+import (
+	"strings"
+	"testing"
+
+	"ytdlp-site/database/dbtest"
+)
+
+func TestFeedKey(t *testing.T) {
+	db := dbtest.Open(t, &User{})
+	user, err := CreateUser(db, "alice", "password", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ResetFeedKey(db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.First(&user, user.ID)
+	if user.FeedKeyHash == "" || strings.Contains(user.FeedKeyHash, key) {
+		t.Errorf("stored %q for key %q, want only its hash", user.FeedKeyHash, key)
+	}
+	if found, err := LookupFeedKey(db, key); err != nil || found.ID != user.ID {
+		t.Fatalf("LookupFeedKey = user %d, %v; want user %d", found.ID, err, user.ID)
+	}
+	for _, bad := range []string{"", user.FeedKeyHash, key[:len(key)-1]} {
+		if _, err := LookupFeedKey(db, bad); err == nil {
+			t.Errorf("LookupFeedKey(%q) found a user", bad)
+		}
+	}
+
+	newKey, err := ResetFeedKey(db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LookupFeedKey(db, key); err == nil {
+		t.Error("the old key still works after a reset")
+	}
+	if _, err := LookupFeedKey(db, newKey); err != nil {
+		t.Errorf("the new key doesn't work: %v", err)
+	}
+
+	db.Model(&user).Update("disabled", true)
+	if _, err := LookupFeedKey(db, newKey); err == nil {
+		t.Error("a disabled user's key works")
+	}
+}
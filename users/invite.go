@@ -0,0 +1,61 @@
+package users
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// a single-use code that allows someone to register an account
+type Invite struct {
+	gorm.Model
+	Code      string `gorm:"uniqueIndex"`
+	CreatedBy uint   // User.ID
+	UsedBy    uint   // User.ID, 0 if unused
+	ExpiresAt time.Time
+}
+
+func (i Invite) Used() bool {
+	return i.UsedBy != 0
+}
+
+func (i Invite) Expired() bool {
+	return time.Now().After(i.ExpiresAt)
+}
+
+func CreateInvite(db *gorm.DB, createdBy uint, ttl time.Duration) (Invite, error) {
+	code, err := randomString(12)
+	if err != nil {
+		return Invite{}, err
+	}
+	invite := Invite{
+		Code:      code,
+		CreatedBy: createdBy,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := db.Create(&invite).Error; err != nil {
+		return Invite{}, err
+	}
+	return invite, nil
+}
+
+// RedeemInvite creates a user with an unused, unexpired invite code
+func RedeemInvite(db *gorm.DB, code, username, password string) (User, error) {
+	var user User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var invite Invite
+		err := tx.Where("code = ? AND used_by = ? AND expires_at > ?", code, 0, time.Now()).
+			First(&invite).Error
+		if err != nil {
+			return fmt.Errorf("invalid or expired invite")
+		}
+
+		user, err = CreateUser(tx, username, password, false)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&invite).Update("used_by", user.ID).Error
+	})
+	return user, err
+}
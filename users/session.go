@@ -0,0 +1,67 @@
+package users
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// a logged-in browser session. The session cookie only holds Key, so
+// deleting the record logs that browser out
+type Session struct {
+	gorm.Model
+	UserID    uint
+	Key       string `gorm:"uniqueIndex" json:"-"`
+	UserAgent string
+	IP        string
+	LastSeen  time.Time
+	ExpiresAt time.Time
+}
+
+func CreateSession(db *gorm.DB, userID uint, userAgent, ip string, ttl time.Duration) (Session, error) {
+	key, err := randomString(32)
+	if err != nil {
+		return Session{}, err
+	}
+	now := time.Now()
+	session := Session{
+		UserID:    userID,
+		Key:       key,
+		UserAgent: userAgent,
+		IP:        ip,
+		LastSeen:  now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		return Session{}, err
+	}
+	return session, nil
+}
+
+// LookupSession returns the unexpired session for a key, and records its use
+func LookupSession(db *gorm.DB, key string) (Session, error) {
+	var session Session
+	err := db.Where("key = ? AND expires_at > ?", key, time.Now()).First(&session).Error
+	if err != nil {
+		return Session{}, err
+	}
+	// don't write on every request
+	if time.Since(session.LastSeen) > time.Minute {
+		db.Model(&session).Update("last_seen", time.Now())
+	}
+	return session, nil
+}
+
+func DeleteSession(db *gorm.DB, key string) error {
+	return db.Unscoped().Where("key = ?", key).Delete(&Session{}).Error
+}
+
+// DeleteSessions logs a user out everywhere
+func DeleteSessions(db *gorm.DB, userID uint) error {
+	return db.Unscoped().Where("user_id = ?", userID).Delete(&Session{}).Error
+}
+
+func DeleteExpiredSessions(db *gorm.DB) (int64, error) {
+	result := db.Unscoped().Where("expires_at < ?", time.Now()).Delete(&Session{})
+	return result.RowsAffected, result.Error
+}
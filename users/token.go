@@ -0,0 +1,120 @@
+package users
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+type Scope string
+
+const (
+	ScopeRead   Scope = "read"   // GET requests
+	ScopeSubmit Scope = "submit" // only submit URLs for download
+	ScopeAdmin  Scope = "admin"  // anything the user can do
+)
+
+const tokenPrefix = "ytd"
+
+// an API token for non-browser clients
+type Token struct {
+	gorm.Model
+	UserID   uint
+	Name     string
+	Prefix   string `gorm:"uniqueIndex"` // identifies the token without revealing it
+	Hash     string `json:"-"`           // bcrypt hash of the whole token
+	Scopes   string // comma-separated Scopes
+	LastUsed time.Time
+}
+
+func ParseScope(s string) (Scope, error) {
+	switch scope := Scope(strings.TrimSpace(s)); scope {
+	case ScopeRead, ScopeSubmit, ScopeAdmin:
+		return scope, nil
+	default:
+		return "", fmt.Errorf("unknown scope %q", s)
+	}
+}
+
+func (t Token) HasScope(scope Scope) bool {
+	for _, s := range strings.Split(t.Scopes, ",") {
+		if Scope(s) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CreateToken creates a token for a user, returning the record and the token itself.
+// The token is only stored hashed, so it can't be retrieved again later
+func CreateToken(db *gorm.DB, userID uint, name string, scopes []Scope) (Token, string, error) {
+	if len(scopes) == 0 {
+		return Token{}, "", fmt.Errorf("at least one scope is required")
+	}
+
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return Token{}, "", err
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return Token{}, "", err
+	}
+	prefix := hex.EncodeToString(id)
+	plaintext := fmt.Sprintf("%s_%s_%s", tokenPrefix, prefix, secret)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintext), bcrypt.DefaultCost)
+	if err != nil {
+		return Token{}, "", err
+	}
+
+	var scopeStrs []string
+	for _, scope := range scopes {
+		scopeStrs = append(scopeStrs, string(scope))
+	}
+
+	token := Token{
+		UserID: userID,
+		Name:   name,
+		Prefix: prefix,
+		Hash:   string(hash),
+		Scopes: strings.Join(scopeStrs, ","),
+	}
+	if err := db.Create(&token).Error; err != nil {
+		return Token{}, "", err
+	}
+	return token, plaintext, nil
+}
+
+// LookupToken returns the token record matching a token, and records its use
+func LookupToken(db *gorm.DB, plaintext string) (Token, error) {
+	parts := strings.SplitN(plaintext, "_", 3)
+	if len(parts) != 3 || parts[0] != tokenPrefix {
+		return Token{}, fmt.Errorf("malformed token")
+	}
+
+	var token Token
+	if err := db.Where("prefix = ?", parts[1]).First(&token).Error; err != nil {
+		return Token{}, err
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(token.Hash), []byte(plaintext)); err != nil {
+		return Token{}, err
+	}
+
+	db.Model(&token).Update("last_used", time.Now())
+	return token, nil
+}
@@ -0,0 +1,140 @@
+package users
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+
+	"ytdlp-site/totp"
+)
+
+const numRecoveryCodes = 10
+
+// a single-use code that can replace a TOTP code, for a lost authenticator
+type RecoveryCode struct {
+	gorm.Model
+	UserID uint
+	Hash   string
+}
+
+// BeginTOTP stores a new, not yet enabled, secret for a user and returns it
+func BeginTOTP(db *gorm.DB, userID uint) (string, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	err = db.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_enabled":   false,
+		"totp_last_step": 0,
+	}).Error
+	return secret, err
+}
+
+// VerifyTOTP checks a code against the user's secret at time now.
+// Each code is only accepted once
+func VerifyTOTP(db *gorm.DB, user *User, code string, now time.Time) bool {
+	if user.TOTPSecret == "" {
+		return false
+	}
+	step, ok := totp.Validate(user.TOTPSecret, code, now)
+	if !ok || step <= user.TOTPLastStep {
+		return false
+	}
+	// only one request can advance the step past a given code
+	result := db.Model(&User{}).
+		Where("id = ? AND totp_last_step < ?", user.ID, step).
+		Update("totp_last_step", step)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+	user.TOTPLastStep = step
+	return true
+}
+
+// EnableTOTP turns on 2FA once the user has proven their authenticator works,
+// and returns a fresh set of recovery codes
+func EnableTOTP(db *gorm.DB, user *User, code string, now time.Time) ([]string, error) {
+	if !VerifyTOTP(db, user, code, now) {
+		return nil, fmt.Errorf("invalid code")
+	}
+	if err := db.Model(user).Update("totp_enabled", true).Error; err != nil {
+		return nil, err
+	}
+	return NewRecoveryCodes(db, user.ID)
+}
+
+func DisableTOTP(db *gorm.DB, userID uint) error {
+	err := db.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_secret":    "",
+		"totp_enabled":   false,
+		"totp_last_step": 0,
+	}).Error
+	if err != nil {
+		return err
+	}
+	return db.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// NewRecoveryCodes replaces a user's recovery codes. The codes are only stored hashed
+func NewRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	var codes []string
+	var records []RecoveryCode
+	for i := 0; i < numRecoveryCodes; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := hex.EncodeToString(b)
+		code := raw[:5] + "-" + raw[5:]
+		hash, err := bcrypt.GenerateFromPassword([]byte(normalizeRecoveryCode(code)), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+		records = append(records, RecoveryCode{UserID: userID, Hash: string(hash)})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&records).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode consumes a matching recovery code
+func UseRecoveryCode(db *gorm.DB, userID uint, code string) bool {
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return false
+	}
+	var records []RecoveryCode
+	db.Where("user_id = ?", userID).Find(&records)
+	for _, r := range records {
+		if bcrypt.CompareHashAndPassword([]byte(r.Hash), []byte(code)) == nil {
+			result := db.Unscoped().Delete(&r)
+			return result.Error == nil && result.RowsAffected == 1
+		}
+	}
+	return false
+}
+
+func CountRecoveryCodes(db *gorm.DB, userID uint) int64 {
+	var n int64
+	db.Model(&RecoveryCode{}).Where("user_id = ?", userID).Count(&n)
+	return n
+}
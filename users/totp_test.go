@@ -0,0 +1,103 @@
+package users
+
+import (
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"ytdlp-site/database/dbtest"
+	"ytdlp-site/totp"
+)
+
+// a user enrolled in TOTP at time now
+func createTOTPUser(t *testing.T, db *gorm.DB, now time.Time) (User, []string) {
+	t.Helper()
+	user, err := CreateUser(db, "alice", "password", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := BeginTOTP(db, user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.TOTPSecret = secret
+	code, _ := totp.Code(secret, now)
+	codes, err := EnableTOTP(db, &user, code, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user, codes
+}
+
+func TestVerifyTOTPRejectsReplays(t *testing.T) {
+	db := dbtest.Open(t, &User{}, &RecoveryCode{})
+	now := time.Unix(1700000000, 0)
+	user, _ := createTOTPUser(t, db, now)
+
+	// the enrollment code can't be used again, even within its window
+	code, _ := totp.Code(user.TOTPSecret, now)
+	if VerifyTOTP(db, &user, code, now.Add(10*time.Second)) {
+		t.Error("the code used to enable TOTP was accepted again")
+	}
+
+	later := now.Add(totp.Period)
+	code, _ = totp.Code(user.TOTPSecret, later)
+	if !VerifyTOTP(db, &user, code, later) {
+		t.Fatal("the next code was rejected")
+	}
+	if VerifyTOTP(db, &user, code, later) {
+		t.Error("a code was accepted twice")
+	}
+
+	// a stale copy of the user, as another request would have loaded it,
+	// is stopped by the stored last step
+	var stale User
+	db.First(&stale, user.ID)
+	stale.TOTPLastStep = 0
+	if VerifyTOTP(db, &stale, code, later) {
+		t.Error("a code was replayed through a stale user record")
+	}
+	db.First(&stale, user.ID)
+	if stale.TOTPLastStep != totp.Step(later) {
+		t.Errorf("TOTPLastStep = %d, want %d", stale.TOTPLastStep, totp.Step(later))
+	}
+
+	// an older code inside the window is rejected once a newer one was used
+	earlier, _ := totp.Code(user.TOTPSecret, now)
+	if VerifyTOTP(db, &user, earlier, later) {
+		t.Error("an older code was accepted after a newer one")
+	}
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	db := dbtest.Open(t, &User{}, &RecoveryCode{})
+	user, codes := createTOTPUser(t, db, time.Unix(1700000000, 0))
+	if len(codes) != numRecoveryCodes {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), numRecoveryCodes)
+	}
+
+	if !UseRecoveryCode(db, user.ID, codes[0]) {
+		t.Fatal("a recovery code was rejected")
+	}
+	if UseRecoveryCode(db, user.ID, codes[0]) {
+		t.Error("a recovery code was accepted twice")
+	}
+	// codes may be typed without the dash, with spaces around them
+	if !UseRecoveryCode(db, user.ID, " "+codes[1][:5]+codes[1][6:]+" ") {
+		t.Error("a recovery code without its dash was rejected")
+	}
+	if UseRecoveryCode(db, user.ID, "") {
+		t.Error("an empty recovery code was accepted")
+	}
+	if n := CountRecoveryCodes(db, user.ID); n != numRecoveryCodes-2 {
+		t.Errorf("%d recovery codes left, want %d", n, numRecoveryCodes-2)
+	}
+
+	if err := DisableTOTP(db, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if UseRecoveryCode(db, user.ID, codes[2]) {
+		t.Error("a recovery code was accepted after disabling TOTP")
+	}
+}
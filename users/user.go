@@ -1,6 +1,9 @@
 package users
 
 import (
+	"errors"
+	"fmt"
+
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -8,14 +11,71 @@ import (
 type User struct {
 	gorm.Model
 	Username string `gorm:"unique"`
-	Password string
+	Password string `json:"-"`
+	IsAdmin  bool
+	Disabled bool // can't log in or use tokens
+
+	QuotaBytes int64 // total size of media, 0 for unlimited
+	QuotaItems uint  // number of downloaded originals, 0 for unlimited
+
+	ProfileID uint // Profile.ID used for new downloads (0 for the default profile)
+
+	FeedKeyHash string `gorm:"index" json:"-"` // SHA-256 of the secret in podcast feed URLs, empty until created
+
+	TOTPSecret   string `json:"-"` // base32, set during enrollment
+	TOTPEnabled  bool
+	TOTPLastStep int64 `json:"-"` // last accepted time step, to prevent replays
 }
 
-func Create(db *gorm.DB, username, password string) error {
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	user := User{Username: username, Password: string(hashedPassword)}
+// CreateUser creates a user with a hashed password and returns the record
+func CreateUser(db *gorm.DB, username, password string, isAdmin bool) (User, error) {
+	if username == "" || password == "" {
+		return User{}, fmt.Errorf("username and password are required")
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+	user := User{Username: username, Password: string(hashedPassword), IsAdmin: isAdmin}
 	if err := db.Create(&user).Error; err != nil {
+		return User{}, err
+	}
+	return user, nil
+}
+
+func SetPassword(db *gorm.DB, id uint, password string) error {
+	if password == "" {
+		return fmt.Errorf("password is required")
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
 		return err
 	}
-	return nil
+	return db.Model(&User{}).Where("id = ?", id).Update("password", string(hashedPassword)).Error
+}
+
+// Provision returns the user with username, creating them on first sight.
+// Provisioned users can't log in with a password until an admin sets one
+func Provision(db *gorm.DB, username string) (User, error) {
+	var user User
+	err := db.Where("username = ?", username).First(&user).Error
+	if err == nil {
+		return user, nil
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return User{}, err
+	}
+
+	password, err := randomString(32)
+	if err != nil {
+		return User{}, err
+	}
+	user, err = CreateUser(db, username, password, false)
+	if err != nil {
+		// someone else may have created them concurrently
+		if err2 := db.Where("username = ?", username).First(&user).Error; err2 == nil {
+			return user, nil
+		}
+		return User{}, err
+	}
+	return user, nil
 }
@@ -1,24 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 	"ytdlp-site/config"
+	"ytdlp-site/diskspace"
 	"ytdlp-site/ffmpeg"
+	"ytdlp-site/jobs"
 	"ytdlp-site/media"
 	"ytdlp-site/originals"
 	"ytdlp-site/transcodes"
 
 	"github.com/google/uuid"
-	"gorm.io/gorm"
 )
 
-const (
-	maxConcurrent = 2
-)
-
-var sem = make(chan struct{}, maxConcurrent)
+var sem = transcodes.Slots
 
 func ensureDirFor(path string) error {
 	dir := filepath.Dir(path)
@@ -26,52 +25,274 @@ func ensureDirFor(path string) error {
 	return os.MkdirAll(dir, 0700)
 }
 
+func setTranscodeRunning(transID uint) {
+	db.Model(&transcodes.Transcode{}).Where("id = ?", transID).Updates(map[string]interface{}{
+		"status":     "running",
+		"time_start": time.Now(),
+		"progress":   0,
+	})
+}
+
+// length in seconds of the source media of a transcode
+func transcodeSrcLength(trans transcodes.Transcode) float64 {
+	if trans.SrcKind == "video" {
+		var video media.Video
+		if err := db.First(&video, "id = ?", trans.SrcID).Error; err == nil {
+			return video.Length
+		}
+	} else if trans.SrcKind == "audio" {
+		var audio media.Audio
+		if err := db.First(&audio, "id = ?", trans.SrcID).Error; err == nil {
+			return audio.Length
+		}
+	}
+	return 0
+}
+
+// returns a function that records ffmpeg progress on a transcode and forwards it to listeners,
+// at most once per second
+func transcodeProgressFunc(trans transcodes.Transcode) func(ffmpeg.Progress) {
+	length := transcodeSrcLength(trans)
+	var last time.Time
+	return func(p ffmpeg.Progress) {
+		if length <= 0 || (!p.Done && time.Since(last) < time.Second) {
+			return
+		}
+		last = time.Now()
+
+		percent := min(100, 100*p.OutTime/length)
+		var eta int64 = -1
+		if p.Speed > 0 {
+			eta = int64((length - p.OutTime) / p.Speed)
+		}
+
+		db.Model(&transcodes.Transcode{}).Where("id = ?", trans.ID).Update("progress", percent)
+		originals.SetTranscodeProgress(trans.OriginalID, originals.TranscodeProgress{
+			ID:      trans.ID,
+			Percent: percent,
+			ETA:     eta,
+		})
+	}
+}
+
+// block while the disk is nearly full, leaving the transcode pending.
+// Returns false if the transcode was cancelled while waiting
+func waitForDiskSpace(transID uint) bool {
+	for !diskspace.Wait(time.Minute) {
+		var count int64
+		db.Model(&transcodes.Transcode{}).Where("id = ?", transID).Count(&count)
+		if count == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// cancel a pending or running transcode
+func cancelTranscode(transID uint) error {
+	var trans transcodes.Transcode
+	if err := db.First(&trans, "id = ?", transID).Error; err != nil {
+		return err
+	}
+
+	// a pending transcode is skipped once its record is gone,
+	// a running one is killed and cleaned up by its worker
+	db.Delete(&trans)
+	if !jobs.Cancel(jobs.Transcode, trans.ID) {
+		originals.SetStatusTranscodingOrCompleted(trans.OriginalID)
+		if trans.DstKind == "video" {
+			go maybeBuildHLS(trans.OriginalID)
+		}
+	}
+	return nil
+}
+
+// mark a transcode failed, and package the videos that did succeed once an
+// original's last video transcode has ended
+func failTranscode(trans transcodes.Transcode) {
+	db.Model(&transcodes.Transcode{}).Where("id = ?", trans.ID).Update("status", "failed")
+	if trans.DstKind == "video" {
+		maybeBuildHLS(trans.OriginalID)
+	}
+}
+
+// if the transcode was cancelled, remove its partial output and return true
+func transcodeCancelled(trans transcodes.Transcode, dstFilepath string) bool {
+	if !jobs.Cancelled(jobs.Transcode, trans.ID) {
+		return false
+	}
+	log.Infoln("transcode", trans.ID, "cancelled")
+	if err := os.Remove(dstFilepath); err != nil && !os.IsNotExist(err) {
+		log.Errorln("error removing", dstFilepath, err)
+	}
+	db.Delete(&trans)
+	originals.SetStatusTranscodingOrCompleted(trans.OriginalID)
+	if trans.DstKind == "video" {
+		maybeBuildHLS(trans.OriginalID)
+	}
+	return true
+}
+
+// software ffmpeg encoders for the codecs a profile may ask for
+var (
+	videoEncoders = map[string]string{
+		"h264": "libx264",
+		"hevc": "libx265",
+		"vp9":  "libvpx-vp9",
+		"av1":  "libsvtav1",
+	}
+	audioEncoders = map[string]string{
+		"aac":  "aac",
+		"opus": "libopus",
+		"mp3":  "mp3",
+		"flac": "flac",
+	}
+)
+
+// the x264-style preset names mapped onto libvpx-vp9 -cpu-used and libsvtav1 -preset
+var (
+	vp9CPUUsed = map[string]int{
+		"ultrafast": 5, "superfast": 5, "veryfast": 4, "faster": 4, "fast": 3,
+		"medium": 2, "slow": 1, "slower": 0, "veryslow": 0,
+	}
+	svtav1Presets = map[string]int{
+		"ultrafast": 12, "superfast": 11, "veryfast": 10, "faster": 9, "fast": 8,
+		"medium": 6, "slow": 4, "slower": 3, "veryslow": 2,
+	}
+)
+
+// ffmpeg output options for the audio stream of a transcode
+func audioCodecArgs(trans transcodes.Transcode) ([]string, error) {
+	encoder, ok := audioEncoders[trans.AudioCodec]
+	if !ok {
+		return nil, fmt.Errorf("unsupported audio codec %q", trans.AudioCodec)
+	}
+	if trans.AudioCodec == "flac" {
+		return []string{"-c:a", encoder}, nil // lossless, no bitrate
+	}
+	return []string{"-c:a", encoder, "-b:a", fmt.Sprintf("%dk", trans.Kbps)}, nil
+}
+
+// ffmpeg output options for a video transcode
+func videoTranscodeArgs(trans transcodes.Transcode) ([]string, error) {
+	encoder, ok := videoEncoders[trans.VideoCodec]
+	if !ok {
+		return nil, fmt.Errorf("unsupported video codec %q", trans.VideoCodec)
+	}
+	audioArgs, err := audioCodecArgs(trans)
+	if err != nil {
+		return nil, err
+	}
+
+	var vf string
+	if trans.FPS > 0 {
+		vf = fmt.Sprintf("scale=-2:%d,fps=%f", trans.Height, trans.FPS)
+	} else {
+		vf = fmt.Sprintf("scale=-2:%d", trans.Height)
+	}
+	args := []string{"-vf", vf, "-c:v", encoder}
+
+	if trans.VideoKbps > 0 {
+		args = append(args, "-b:v", fmt.Sprintf("%dk", trans.VideoKbps))
+	} else {
+		args = append(args, "-crf", fmt.Sprint(trans.CRF))
+		if trans.VideoCodec == "vp9" {
+			args = append(args, "-b:v", "0") // constant quality rather than constrained
+		}
+	}
+
+	switch trans.VideoCodec {
+	case "h264", "hevc":
+		if trans.Preset != "" {
+			args = append(args, "-preset", trans.Preset)
+		}
+	case "vp9":
+		args = append(args, "-row-mt", "1", "-deadline", "good")
+		if cpuUsed, ok := vp9CPUUsed[trans.Preset]; ok {
+			args = append(args, "-cpu-used", fmt.Sprint(cpuUsed))
+		}
+	case "av1":
+		if preset, ok := svtav1Presets[trans.Preset]; ok {
+			args = append(args, "-preset", fmt.Sprint(preset))
+		}
+	}
+	if trans.KeyframeSeconds > 0 {
+		// keyframes at the same times in every rendition, so HLS segments line up
+		args = append(args, "-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", trans.KeyframeSeconds))
+	}
+	if trans.VideoCodec == "hevc" && trans.Container == "mp4" {
+		args = append(args, "-tag:v", "hvc1") // so Apple devices will play it
+	}
+	if trans.Container == "mp4" {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return append(args, audioArgs...), nil
+}
+
+// ffmpeg output options for an audio transcode
+func audioTranscodeArgs(trans transcodes.Transcode) ([]string, error) {
+	audioArgs, err := audioCodecArgs(trans)
+	if err != nil {
+		return nil, err
+	}
+	args := append([]string{"-vn"}, audioArgs...)
+	if trans.Container == "m4a" {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return args, nil
+}
+
 func videoToVideo(sem chan struct{}, transID uint, srcFilepath string) {
 	sem <- struct{}{}        // Acquire semaphore
 	defer func() { <-sem }() // release semaphore
+	// checked once a slot is free, so queued transcodes don't all pass
+	// while there is room and then start together
+	if !waitForDiskSpace(transID) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs.Register(jobs.Transcode, transID, cancel)
+	defer jobs.Unregister(jobs.Transcode, transID)
 
 	var trans transcodes.Transcode
-	db.First(&trans, "id = ?", transID)
+	if err := db.First(&trans, "id = ?", transID).Error; err != nil {
+		log.Debugln("skip transcode", transID, err) // e.g. cancelled while pending
+		return
+	}
+	trans.ApplyDefaults()
 	originals.SetStatus(trans.OriginalID, originals.StatusTranscoding)
 
 	// determine destination path
 	dstFilename := uuid.Must(uuid.NewV7()).String()
-	dstFilename = fmt.Sprintf("%s.mp4", dstFilename)
+	dstFilename = fmt.Sprintf("%s.%s", dstFilename, trans.Container)
 	dstFilepath := filepath.Join(config.GetDataDir(), dstFilename)
 
-	err := ensureDirFor(dstFilepath)
+	args, err := videoTranscodeArgs(trans)
 	if err != nil {
-		fmt.Println("Error: couldn't create dir for ", dstFilepath, err)
-		db.Model(&transcodes.Transcode{}).Where("id = ?", trans.ID).Update("status", "failed")
+		log.Errorln("transcode", trans.ID, err)
+		failTranscode(trans)
 		return
 	}
 
-	// FIXME: ignoring any requested audio bitrate
-	// determine audio bitrate
-	var audioBitrate uint = 160
-	if trans.Height <= 240 {
-		audioBitrate = 64
-	} else if trans.Height <= 540 {
-		audioBitrate = 96
-	} else if trans.Height < 720 {
-		audioBitrate = 128
+	err = ensureDirFor(dstFilepath)
+	if err != nil {
+		fmt.Println("Error: couldn't create dir for ", dstFilepath, err)
+		failTranscode(trans)
+		return
 	}
 
 	// start ffmpeg
-	db.Model(&transcodes.Transcode{}).Where("id = ?", trans.ID).Update("status", "running")
-	var vf string
-	if trans.FPS > 0 {
-		vf = fmt.Sprintf("scale=-2:%d,fps=%f", trans.Height, trans.FPS)
-	} else {
-		vf = fmt.Sprintf("scale=-2:%d", trans.Height)
-	}
-	stdout, stderr, err := ffmpeg.Ffmpeg("-i", srcFilepath,
-		"-vf", vf, "-c:v", "libx264",
-		"-crf", "23", "-preset", "fast", "-c:a", "aac", "-b:a", fmt.Sprintf("%dk", audioBitrate),
-		dstFilepath)
+	setTranscodeRunning(trans.ID)
+	args = append(append([]string{"-i", srcFilepath}, args...), dstFilepath)
+	stderr, err := ffmpeg.FfmpegProgress(ctx, transcodeProgressFunc(trans), args...)
 	if err != nil {
-		fmt.Println("Error: convert to video file", srcFilepath, "->", dstFilepath, string(stdout), string(stderr))
-		db.Model(&transcodes.Transcode{}).Where("id = ?", trans.ID).Update("status", "failed")
+		if transcodeCancelled(trans, dstFilepath) {
+			return
+		}
+		fmt.Println("Error: convert to video file", srcFilepath, "->", dstFilepath, string(stderr))
+		failTranscode(trans)
 		return
 	}
 
@@ -105,41 +326,65 @@ func videoToVideo(sem chan struct{}, transID uint, srcFilepath string) {
 		video.Height = meta.height
 		video.FPS = meta.fps
 	}
+	setMediaInfo(&video.MediaFile, dstFilepath, true)
 
 	db.Create(&video)
 
 	// complete transcode
 	db.Delete(&trans)
 	originals.SetStatusTranscodingOrCompleted(trans.OriginalID)
+	maybeBuildHLS(trans.OriginalID)
 }
 
 func videoToAudio(sem chan struct{}, transID uint, videoFilepath string) {
 	sem <- struct{}{}        // Acquire semaphore
 	defer func() { <-sem }() // release semaphore
+	// checked once a slot is free, so queued transcodes don't all pass
+	// while there is room and then start together
+	if !waitForDiskSpace(transID) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs.Register(jobs.Transcode, transID, cancel)
+	defer jobs.Unregister(jobs.Transcode, transID)
 
 	var trans transcodes.Transcode
-	db.First(&trans, "id = ?", transID)
+	if err := db.First(&trans, "id = ?", transID).Error; err != nil {
+		log.Debugln("skip transcode", transID, err) // e.g. cancelled while pending
+		return
+	}
+	trans.ApplyDefaults()
 	originals.SetStatus(trans.OriginalID, originals.StatusTranscoding)
 
 	// determine destination path
 	audioFilename := uuid.Must(uuid.NewV7()).String()
-	audioFilename = fmt.Sprintf("%s.mp3", audioFilename)
+	audioFilename = fmt.Sprintf("%s.%s", audioFilename, trans.Container)
 	audioFilepath := filepath.Join(config.GetDataDir(), audioFilename)
 
+	args, err := audioTranscodeArgs(trans)
+	if err != nil {
+		log.Errorln("transcode", trans.ID, err)
+		db.Model(&transcodes.Transcode{}).Where("id = ?", transID).Update("status", "failed")
+		return
+	}
+
 	// ensure destination directory
-	err := ensureDirFor(audioFilepath)
+	err = ensureDirFor(audioFilepath)
 	if err != nil {
 		fmt.Println("Error: couldn't create dir for ", audioFilepath, err)
 		db.Model(&transcodes.Transcode{}).Where("id = ?", transID).Update("status", "failed")
 		return
 	}
 
-	db.Model(&transcodes.Transcode{}).Where("id = ?", transID).Update("status", "running")
-	_, _, err = ffmpeg.Ffmpeg("-i", videoFilepath, "-vn", "-acodec",
-		"mp3", "-b:a",
-		fmt.Sprintf("%dk", trans.Kbps),
-		audioFilepath)
+	setTranscodeRunning(transID)
+	args = append(append([]string{"-i", videoFilepath}, args...), audioFilepath)
+	_, err = ffmpeg.FfmpegProgress(ctx, transcodeProgressFunc(trans), args...)
 	if err != nil {
+		if transcodeCancelled(trans, audioFilepath) {
+			return
+		}
 		fmt.Println("Error: convert to audio file", videoFilepath, "->", audioFilepath)
 		db.Model(&transcodes.Transcode{}).Where("id = ?", transID).Update("status", "failed")
 		return
@@ -168,6 +413,12 @@ func videoToAudio(sem chan struct{}, transID uint, videoFilepath string) {
 	if err == nil {
 		audio.Length = length
 	}
+	if trans.AudioCodec == "flac" {
+		if bps, err := getAudioBitrate(audioFilepath); err == nil {
+			audio.Bps = bps
+		}
+	}
+	setMediaInfo(&audio.MediaFile, audioFilepath, false)
 
 	db.Create(&audio)
 
@@ -179,31 +430,53 @@ func videoToAudio(sem chan struct{}, transID uint, videoFilepath string) {
 func audioToAudio(sem chan struct{}, transID uint, srcFilepath string) {
 	sem <- struct{}{}        // Acquire semaphore
 	defer func() { <-sem }() // release semaphore
+	// checked once a slot is free, so queued transcodes don't all pass
+	// while there is room and then start together
+	if !waitForDiskSpace(transID) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jobs.Register(jobs.Transcode, transID, cancel)
+	defer jobs.Unregister(jobs.Transcode, transID)
 
 	var trans transcodes.Transcode
-	db.First(&trans, "id = ?", transID)
+	if err := db.First(&trans, "id = ?", transID).Error; err != nil {
+		log.Debugln("skip transcode", transID, err) // e.g. cancelled while pending
+		return
+	}
+	trans.ApplyDefaults()
 
 	originals.SetStatus(trans.OriginalID, originals.StatusTranscoding)
 
 	// determine destination path
 	dstFilename := uuid.Must(uuid.NewV7()).String()
-	dstFilename = fmt.Sprintf("%s.mp3", dstFilename)
+	dstFilename = fmt.Sprintf("%s.%s", dstFilename, trans.Container)
 	dstFilepath := filepath.Join(config.GetDataDir(), dstFilename)
 
+	args, err := audioTranscodeArgs(trans)
+	if err != nil {
+		log.Errorln("transcode", trans.ID, err)
+		db.Model(&transcodes.Transcode{}).Where("id = ?", transID).Update("status", "failed")
+		return
+	}
+
 	// ensure destination directory
-	err := ensureDirFor(dstFilepath)
+	err = ensureDirFor(dstFilepath)
 	if err != nil {
 		fmt.Println("Error: couldn't create dir for ", dstFilepath, err)
 		db.Model(&transcodes.Transcode{}).Where("id = ?", transID).Update("status", "failed")
 		return
 	}
 
-	db.Model(&transcodes.Transcode{}).Where("id = ?", transID).Update("status", "running")
-	_, _, err = ffmpeg.Ffmpeg("-i", srcFilepath, "-vn", "-acodec",
-		"mp3", "-b:a",
-		fmt.Sprintf("%dk", trans.Kbps),
-		dstFilepath)
+	setTranscodeRunning(transID)
+	args = append(append([]string{"-i", srcFilepath}, args...), dstFilepath)
+	_, err = ffmpeg.FfmpegProgress(ctx, transcodeProgressFunc(trans), args...)
 	if err != nil {
+		if transcodeCancelled(trans, dstFilepath) {
+			return
+		}
 		fmt.Println("Error: convert to audio file", srcFilepath, "->", dstFilepath)
 		db.Model(&transcodes.Transcode{}).Where("id = ?", transID).Update("status", "failed")
 		return
@@ -231,6 +504,12 @@ func audioToAudio(sem chan struct{}, transID uint, srcFilepath string) {
 	if err == nil {
 		audio.Length = length
 	}
+	if trans.AudioCodec == "flac" {
+		if bps, err := getAudioBitrate(dstFilepath); err == nil {
+			audio.Bps = bps
+		}
+	}
+	setMediaInfo(&audio.MediaFile, dstFilepath, false)
 
 	db.Create(&audio)
 
@@ -272,19 +551,21 @@ func cleanupTranscodes() {
 		Update("status", originals.StatusCompleted)
 
 	// start any existing transcode jobs
-	for {
-		var trans transcodes.Transcode
-		err := db.Where("status = ?", "pending").
-			Order("CASE " +
-				"WHEN dst_kind = 'video' AND height = 540 THEN 0 " +
-				"WHEN dst_kind = 'audio' AND rate = 96 THEN 0 " +
-				"ELSE 1 END").First(&trans).Error
-		// err := db.First(&trans, "status = ?", "pending").Error
-		if err == gorm.ErrRecordNotFound {
-			log.Traceln("no pending transcode jobs")
-			break // no more pending jobs
-		}
+	var pending []transcodes.Transcode
+	err := db.Where("status = ?", "pending").
+		Order("CASE " +
+			"WHEN dst_kind = 'video' AND height = 540 THEN 0 " +
+			"WHEN dst_kind = 'audio' AND kbps = 96 THEN 0 " +
+			"ELSE 1 END").Find(&pending).Error
+	if err != nil {
+		log.Errorln("couldn't retrieve pending transcode jobs:", err)
+		return
+	}
+	if len(pending) == 0 {
+		log.Traceln("no pending transcode jobs")
+	}
 
+	for _, trans := range pending {
 		if trans.SrcKind == "video" {
 
 			var srcVideo media.Video
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"ytdlp-site/transcodes"
+)
+
+func TestVideoTranscodeArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		trans transcodes.Transcode
+		want  string // "" for an error
+	}{
+		{"defaults",
+			transcodes.Transcode{DstKind: "video", Height: 540},
+			"-vf scale=-2:540 -c:v libx264 -crf 23 -preset fast -movflags +faststart -c:a aac -b:a 96k"},
+		{"h264 bitrate and fps",
+			transcodes.Transcode{DstKind: "video", Height: 720, FPS: 30, VideoKbps: 2500, Preset: "slow"},
+			"-vf scale=-2:720,fps=30.000000 -c:v libx264 -b:v 2500k -preset slow -movflags +faststart -c:a aac -b:a 160k"},
+		{"hevc mp4 is tagged for apple",
+			transcodes.Transcode{DstKind: "video", Height: 1080, VideoCodec: "hevc", CRF: 28},
+			"-vf scale=-2:1080 -c:v libx265 -crf 28 -preset fast -tag:v hvc1 -movflags +faststart -c:a aac -b:a 160k"},
+		{"hevc mkv",
+			transcodes.Transcode{DstKind: "video", Height: 1080, VideoCodec: "hevc", CRF: 28, Container: "mkv", AudioCodec: "flac"},
+			"-vf scale=-2:1080 -c:v libx265 -crf 28 -preset fast -c:a flac"},
+		{"vp9 constant quality",
+			transcodes.Transcode{DstKind: "video", Height: 360, VideoCodec: "vp9", CRF: 33, Container: "webm", AudioCodec: "opus", Kbps: 96},
+			"-vf scale=-2:360 -c:v libvpx-vp9 -crf 33 -b:v 0 -row-mt 1 -deadline good -cpu-used 3 -c:a libopus -b:a 96k"},
+		{"vp9 bitrate",
+			transcodes.Transcode{DstKind: "video", Height: 360, VideoCodec: "vp9", VideoKbps: 800, Preset: "veryslow", Container: "webm", AudioCodec: "opus", Kbps: 96},
+			"-vf scale=-2:360 -c:v libvpx-vp9 -b:v 800k -row-mt 1 -deadline good -cpu-used 0 -c:a libopus -b:a 96k"},
+		{"av1",
+			transcodes.Transcode{DstKind: "video", Height: 480, VideoCodec: "av1", CRF: 35, Preset: "medium", Container: "mkv", AudioCodec: "opus", Kbps: 64},
+			"-vf scale=-2:480 -c:v libsvtav1 -crf 35 -preset 6 -c:a libopus -b:a 64k"},
+		{"hls keyframes",
+			transcodes.Transcode{DstKind: "video", Height: 720, KeyframeSeconds: 6},
+			"-vf scale=-2:720 -c:v libx264 -crf 23 -preset fast -force_key_frames expr:gte(t,n_forced*6) -movflags +faststart -c:a aac -b:a 160k"},
+		{"av1 hls keyframes",
+			transcodes.Transcode{DstKind: "video", Height: 480, VideoCodec: "av1", CRF: 35, Container: "mp4", KeyframeSeconds: 6},
+			"-vf scale=-2:480 -c:v libsvtav1 -crf 35 -preset 8 -force_key_frames expr:gte(t,n_forced*6) -movflags +faststart -c:a aac -b:a 96k"},
+		{"unknown video codec",
+			transcodes.Transcode{DstKind: "video", Height: 480, VideoCodec: "mpeg2"}, ""},
+		{"unknown audio codec",
+			transcodes.Transcode{DstKind: "video", Height: 480, AudioCodec: "vorbis"}, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			trans := tc.trans
+			trans.ApplyDefaults()
+			args, err := videoTranscodeArgs(trans)
+			if tc.want == "" {
+				if err == nil {
+					t.Errorf("got %q, want an error", args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(args, " "); got != tc.want {
+				t.Errorf("got  %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAudioTranscodeArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		trans transcodes.Transcode
+		want  string // "" for an error
+	}{
+		{"defaults", transcodes.Transcode{DstKind: "audio"}, "-vn -c:a mp3 -b:a 64k"},
+		{"aac goes in m4a", transcodes.Transcode{DstKind: "audio", AudioCodec: "aac", Kbps: 128},
+			"-vn -c:a aac -b:a 128k -movflags +faststart"},
+		{"opus", transcodes.Transcode{DstKind: "audio", AudioCodec: "opus", Kbps: 48}, "-vn -c:a libopus -b:a 48k"},
+		{"flac has no bitrate", transcodes.Transcode{DstKind: "audio", AudioCodec: "flac", Kbps: 320}, "-vn -c:a flac"},
+		{"unknown codec", transcodes.Transcode{DstKind: "audio", AudioCodec: "vorbis"}, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			trans := tc.trans
+			trans.ApplyDefaults()
+			args, err := audioTranscodeArgs(trans)
+			if tc.want == "" {
+				if err == nil {
+					t.Errorf("got %q, want an error", args)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(args, " "); got != tc.want {
+				t.Errorf("got  %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package ytdlp
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Progress struct {
+	Downloaded int64   // bytes downloaded so far
+	Total      int64   // total bytes (estimated if not known), 0 if unknown
+	Speed      float64 // bytes / second
+	ETA        int64   // seconds remaining, -1 if unknown
+}
+
+const progressPrefix = "[ytdlp-site-progress]"
+
+// yt-dlp arguments that print one parseable progress line per update
+var progressArgs = []string{
+	"--newline",
+	"--progress-template",
+	"download:" + progressPrefix +
+		" %(progress.downloaded_bytes)s" +
+		" %(progress.total_bytes)s" +
+		" %(progress.total_bytes_estimate)s" +
+		" %(progress.speed)s" +
+		" %(progress.eta)s",
+}
+
+// yt-dlp prints "NA" for unavailable fields
+func parseField(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// parse a line produced by the progress template
+func parseProgress(line string) (Progress, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, progressPrefix) {
+		return Progress{}, false
+	}
+	fields := strings.Fields(strings.TrimPrefix(line, progressPrefix))
+	if len(fields) != 5 {
+		return Progress{}, false
+	}
+
+	p := Progress{ETA: -1}
+	if v, ok := parseField(fields[0]); ok {
+		p.Downloaded = int64(v)
+	}
+	if v, ok := parseField(fields[1]); ok {
+		p.Total = int64(v)
+	} else if v, ok := parseField(fields[2]); ok {
+		p.Total = int64(v)
+	}
+	if v, ok := parseField(fields[3]); ok {
+		p.Speed = v
+	}
+	if v, ok := parseField(fields[4]); ok {
+		p.ETA = int64(v)
+	}
+	return p, true
+}
+
+// progressWriter passes progress lines to a callback and
+// buffers everything else
+type progressWriter struct {
+	mu       *sync.Mutex // shared by stdout and stderr so callbacks never overlap
+	buf      *bytes.Buffer
+	line     []byte
+	progress func(Progress)
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, b := range p {
+		w.line = append(w.line, b)
+		if b != '\n' {
+			continue
+		}
+		if prog, ok := parseProgress(string(w.line)); ok {
+			w.progress(prog)
+		} else {
+			w.buf.Write(w.line)
+		}
+		w.line = w.line[:0]
+	}
+	return len(p), nil
+}
@@ -0,0 +1,36 @@
+package ytdlp
+
+import "testing"
+
+func TestParseProgress(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want Progress
+		ok   bool
+	}{
+		{"all fields", "[ytdlp-site-progress] 1024 4096 NA 512.5 6\n",
+			Progress{Downloaded: 1024, Total: 4096, Speed: 512.5, ETA: 6}, true},
+		{"estimated total", "[ytdlp-site-progress] 1024 NA 8192.7 NA NA",
+			Progress{Downloaded: 1024, Total: 8192, ETA: -1}, true},
+		{"exact total wins", "[ytdlp-site-progress] 0 4096 8192 NA 10",
+			Progress{Total: 4096, ETA: 10}, true},
+		{"nothing known", "[ytdlp-site-progress] NA NA NA NA NA",
+			Progress{ETA: -1}, true},
+		{"surrounding space", "  [ytdlp-site-progress]  1 2 3 4 5  \r\n",
+			Progress{Downloaded: 1, Total: 2, Speed: 4, ETA: 5}, true},
+		{"too few fields", "[ytdlp-site-progress] 1 2 3 4", Progress{}, false},
+		{"too many fields", "[ytdlp-site-progress] 1 2 3 4 5 6", Progress{}, false},
+		{"other output", "[download] Destination: video.mp4", Progress{}, false},
+		{"prefix not at the start", "echo [ytdlp-site-progress] 1 2 3 4 5", Progress{}, false},
+		{"empty", "", Progress{}, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := parseProgress(tc.line)
+			if ok != tc.ok || got != tc.want {
+				t.Errorf("got %+v %t, want %+v %t", got, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
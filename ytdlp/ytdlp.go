@@ -3,8 +3,10 @@ package ytdlp
 import (
 	"bytes"
 	"context"
+	"io"
 	"os/exec"
 	"strings"
+	"sync"
 )
 
 // runs ffprobe with the provided args and returns (stdout, stderr, error)
@@ -26,15 +28,32 @@ type Cmd struct {
 }
 
 func Start(args ...string) (*Cmd, context.CancelFunc, error) {
+	stdout := new(bytes.Buffer)
+	stderr := new(bytes.Buffer)
+	return start(stdout, stderr, stdout, stderr, args...)
+}
 
-	ytdlp := "yt-dlp"
+// StartProgress is like Start, but calls `progress` each time yt-dlp reports download progress.
+// Calls to `progress` are never concurrent.
+func StartProgress(progress func(Progress), args ...string) (*Cmd, context.CancelFunc, error) {
 	stdout := new(bytes.Buffer)
 	stderr := new(bytes.Buffer)
+	mu := new(sync.Mutex)
+	args = append(append([]string{}, progressArgs...), args...)
+	return start(
+		&progressWriter{mu: mu, buf: stdout, progress: progress},
+		&progressWriter{mu: mu, buf: stderr, progress: progress},
+		stdout, stderr, args...)
+}
+
+func start(outW, errW io.Writer, stdout, stderr *bytes.Buffer, args ...string) (*Cmd, context.CancelFunc, error) {
+
+	ytdlp := "yt-dlp"
 
 	ctx, cancel := context.WithCancel(context.Background())
 	cmd := exec.CommandContext(ctx, ytdlp, args...)
-	cmd.Stdout = stdout
-	cmd.Stderr = stderr
+	cmd.Stdout = outW
+	cmd.Stderr = errW
 
 	log.Infoln(ytdlp, strings.Join(args, " "))
 	err := cmd.Start()